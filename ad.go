@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/asn1"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ADDomain summarises an Active Directory domain from the rootDSE of its
+// domain controllers and the SRV records its DNS publishes. Nothing in it
+// needs credentials.
+type ADDomain struct {
+	Name        string   `json:"name" xml:"name,attr"`
+	Forest      string   `json:"forest" xml:"forest,attr"`
+	Level       string   `json:"domain_level,omitempty" xml:"domain-level,attr,omitempty"` // domain functional level, e.g. "2016"
+	ForestLevel string   `json:"forest_level,omitempty" xml:"forest-level,attr,omitempty"`
+	DCs         []string `json:"dcs,omitempty" xml:"dc,omitempty"`             // from _ldap._tcp.dc._msdcs SRV records
+	GCs         []string `json:"global_catalogs,omitempty" xml:"gc,omitempty"` // from _gc._tcp SRV records of the forest
+	Found       []string `json:"found" xml:"found"`                            // domain controllers this scan found, with name and site
+}
+
+// ldapDeadline bounds the whole rootDSE exchange with one server.
+const ldapDeadline = 10 * time.Second
+
+// maxBERLength caps the LDAP messages readBER accepts. A rootDSE entry is
+// a few kilobytes; the cap keeps a hostile server from making the scanner
+// allocate whatever length it claims.
+const maxBERLength = 1 << 20
+
+// functionalLevels names the msDS-Behavior-Version values of domains and
+// forests by the Windows Server release that introduced them.
+var functionalLevels = map[string]string{
+	"0": "2000", "1": "2003 interim", "2": "2003", "3": "2008",
+	"4": "2008 R2", "5": "2012", "6": "2012 R2", "7": "2016", "10": "2025",
+}
+
+// rootDSEAttrs are the rootDSE attributes the summary is built from.
+var rootDSEAttrs = []string{
+	"defaultNamingContext", "rootDomainNamingContext", "dnsHostName",
+	"serverName", "domainFunctionality", "forestFunctionality",
+}
+
+// adPhase reads the rootDSE of every host that looks like a domain
+// controller, with both Kerberos and LDAP open, and groups what it learns
+// by domain, adding the domain's DCs and global catalogs from DNS. The
+// SRV lookups go to the controller itself when it serves DNS, as the
+// scanning machine's resolver rarely knows the AD zones.
+type adPhase struct{}
+
+func (adPhase) Name() string { return "Active Directory summary" }
+
+func (adPhase) Run(s *Scan) error {
+	if !s.AD {
+		return nil
+	}
+	type dseResult struct {
+		host *HostResult
+		dse  map[string]string
+	}
+	var (
+		mu      sync.Mutex
+		results []dseResult
+	)
+	isDCLDAP := func(h *HostResult, p *PortResult) bool {
+		return p.Port == 389 && slices.Contains(h.OpenPorts(), 88)
+	}
+	s.probePorts(adPhase{}, isDCLDAP, func(h *HostResult, p *PortResult) error {
+		dse, err := ldapRootDSE(h.IP, s.Timeout)
+		if err != nil {
+			p.Notes = append(p.Notes, "LDAP rootDSE query failed: "+err.Error())
+			return nil
+		}
+		mu.Lock()
+		results = append(results, dseResult{h, dse})
+		mu.Unlock()
+		return nil
+	})
+
+	domains := make(map[string]*ADDomain)
+	for _, r := range results {
+		name := dnToDomain(r.dse["defaultNamingContext"])
+		if name == "" {
+			continue
+		}
+		if r.host.Hostname == "" {
+			r.host.Hostname = r.dse["dnsHostName"]
+		}
+		d := domains[name]
+		if d == nil {
+			d = &ADDomain{
+				Name:        name,
+				Forest:      dnToDomain(r.dse["rootDomainNamingContext"]),
+				Level:       functionalLevels[r.dse["domainFunctionality"]],
+				ForestLevel: functionalLevels[r.dse["forestFunctionality"]],
+			}
+			resolver := net.DefaultResolver
+			if slices.Contains(r.host.OpenPorts(), 53) {
+				resolver = pinnedResolver(r.host.IP)
+			}
+			d.DCs = lookupSRV(resolver, "ldap", "dc._msdcs."+d.Name)
+			d.GCs = lookupSRV(resolver, "gc", d.Forest)
+			domains[name] = d
+		}
+		found := r.host.IP
+		if dns := r.dse["dnsHostName"]; dns != "" {
+			found += " " + dns
+		}
+		if site := serverSite(r.dse["serverName"]); site != "" {
+			found += " (site " + site + ")"
+		}
+		d.Found = append(d.Found, found)
+	}
+	for _, d := range domains {
+		s.Domains = append(s.Domains, *d)
+	}
+	slices.SortFunc(s.Domains, func(a, b ADDomain) int { return strings.Compare(a.Name, b.Name) })
+	return nil
+}
+
+// pinnedResolver sends every DNS query to port 53 of ip.
+func pinnedResolver(ip string) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, net.JoinHostPort(ip, "53"))
+		},
+	}
+}
+
+// lookupSRV returns the targets of the _service._tcp SRV records of
+// domain, without the trailing dots, or nil if there are none.
+func lookupSRV(resolver *net.Resolver, service, domain string) []string {
+	if domain == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	probeRate.wait()
+	_, records, err := resolver.LookupSRV(ctx, service, "tcp", domain)
+	if err != nil {
+		return nil
+	}
+	var targets []string
+	for _, r := range records {
+		targets = append(targets, strings.TrimSuffix(r.Target, "."))
+	}
+	slices.Sort(targets)
+	return slices.Compact(targets)
+}
+
+// dnToDomain turns a naming context such as "DC=corp,DC=example,DC=com"
+// into the DNS name "corp.example.com".
+func dnToDomain(dn string) string {
+	var labels []string
+	for _, rdn := range strings.Split(dn, ",") {
+		if k, v, ok := strings.Cut(strings.TrimSpace(rdn), "="); ok && strings.EqualFold(k, "DC") {
+			labels = append(labels, v)
+		}
+	}
+	return strings.Join(labels, ".")
+}
+
+// serverSite extracts the site from a DC's serverName, which has the form
+// "CN=DC1,CN=Servers,CN=<site>,CN=Sites,CN=Configuration,...".
+func serverSite(dn string) string {
+	rdns := strings.Split(dn, ",")
+	for i, rdn := range rdns {
+		if strings.EqualFold(rdn, "CN=Servers") && i+1 < len(rdns) {
+			_, site, _ := strings.Cut(rdns[i+1], "=")
+			return site
+		}
+	}
+	return ""
+}
+
+// ldapRootDSE reads rootDSEAttrs from the LDAP server on port 389 of ip
+// with an anonymous base search of the empty DN, which AD answers before
+// any bind. Each attribute maps to its first value.
+func ldapRootDSE(ip string, timeout time.Duration) (map[string]string, error) {
+	probeRate.wait()
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip, "389"), timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(ldapDeadline))
+
+	var attrs [][]byte
+	for _, a := range rootDSEAttrs {
+		attrs = append(attrs, []byte(a))
+	}
+	// SearchRequest of the rootDSE: base scope, never deref aliases, no
+	// size or time limit, values wanted, filter (objectClass=*).
+	search := ber(asn1.ClassApplication, 3, true,
+		berValue([]byte("")),
+		berValue(asn1.Enumerated(0)), berValue(asn1.Enumerated(0)),
+		berValue(0), berValue(0),
+		berValue(false),
+		ber(asn1.ClassContextSpecific, 7, false, []byte("objectClass")),
+		berValue(attrs))
+	if _, err := conn.Write(ber(asn1.ClassUniversal, asn1.TagSequence, true, berValue(1), search)); err != nil {
+		return nil, err
+	}
+
+	dse := make(map[string]string)
+	r := bufio.NewReader(conn)
+	for {
+		msg, err := readBER(r)
+		if err != nil {
+			return nil, err
+		}
+		var envelope asn1.RawValue
+		if _, err := asn1.Unmarshal(msg, &envelope); err != nil {
+			return nil, fmt.Errorf("malformed LDAP message: %w", err)
+		}
+		var id int
+		var op asn1.RawValue
+		rest, err := asn1.Unmarshal(envelope.Bytes, &id)
+		if err == nil {
+			_, err = asn1.Unmarshal(rest, &op)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("malformed LDAP message: %w", err)
+		}
+		switch op.Tag {
+		case 4: // SearchResultEntry
+			var dn []byte
+			var entry []struct {
+				Type   []byte
+				Values [][]byte `asn1:"set"`
+			}
+			rest, err := asn1.Unmarshal(op.Bytes, &dn)
+			if err == nil {
+				_, err = asn1.Unmarshal(rest, &entry)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("malformed search result: %w", err)
+			}
+			for _, a := range entry {
+				if len(a.Values) > 0 {
+					dse[string(a.Type)] = string(a.Values[0])
+				}
+			}
+		case 5: // SearchResultDone
+			var code asn1.Enumerated
+			if _, err := asn1.Unmarshal(op.Bytes, &code); err != nil {
+				return nil, fmt.Errorf("malformed search result: %w", err)
+			}
+			if code != 0 {
+				return nil, fmt.Errorf("search failed with LDAP result code %d", code)
+			}
+			return dse, nil
+		}
+	}
+}
+
+// ber encodes a BER element with the given class and tag around the
+// concatenated contents.
+func ber(class, tag int, compound bool, contents ...[]byte) []byte {
+	b, _ := asn1.Marshal(asn1.RawValue{Class: class, Tag: tag, IsCompound: compound, Bytes: bytes.Join(contents, nil)})
+	return b
+}
+
+// berValue encodes v with its universal ASN.1 type.
+func berValue(v any) []byte {
+	b, _ := asn1.Marshal(v)
+	return b
+}
+
+// readBER reads one complete BER element, header included, from r.
+func readBER(r *bufio.Reader) ([]byte, error) {
+	header := make([]byte, 2, 6)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	length := uint64(header[1])
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 4 {
+			return nil, fmt.Errorf("unsupported BER length encoding")
+		}
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		header = append(header, b...)
+		length = 0
+		for _, c := range b {
+			length = length<<8 | uint64(c)
+		}
+	}
+	if length > maxBERLength {
+		return nil, fmt.Errorf("BER element of %d bytes is over the %d byte limit", length, maxBERLength)
+	}
+	msg := make([]byte, len(header)+int(length))
+	copy(msg, header)
+	_, err := io.ReadFull(r, msg[len(header):])
+	return msg, err
+}
+
+// printDomains writes the Active Directory section of the summary.
+func printDomains(w io.Writer, domains []ADDomain) {
+	for _, d := range domains {
+		fmt.Fprintf(w, "\nActive Directory domain %s (forest %s", d.Name, d.Forest)
+		if d.Level != "" {
+			fmt.Fprintf(w, ", domain level %s, forest level %s", d.Level, cmp.Or(d.ForestLevel, "unknown"))
+		}
+		fmt.Fprintf(w, "):\n")
+		for _, f := range d.Found {
+			fmt.Fprintf(w, "  found %s\n", f)
+		}
+		if len(d.DCs) > 0 {
+			fmt.Fprintf(w, "  domain controllers in DNS: %s\n", strings.Join(d.DCs, ", "))
+		}
+		if len(d.GCs) > 0 {
+			fmt.Fprintf(w, "  global catalogs in DNS: %s\n", strings.Join(d.GCs, ", "))
+		}
+	}
+}
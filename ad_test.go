@@ -0,0 +1,28 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/asn1"
+	"testing"
+)
+
+func FuzzReadBER(f *testing.F) {
+	f.Add(ber(asn1.ClassUniversal, asn1.TagSequence, true, berValue(1), berValue([]byte("rootDSE"))))
+	f.Add(ber(asn1.ClassUniversal, asn1.TagOctetString, false, make([]byte, 300)))
+	f.Add([]byte{0x30, 0x84, 0xff, 0xff, 0xff, 0xff})
+	f.Add([]byte{0x30, 0x80})
+	f.Add([]byte{0x30})
+	f.Fuzz(func(t *testing.T, b []byte) {
+		msg, err := readBER(bufio.NewReader(bytes.NewReader(b)))
+		if err != nil {
+			return
+		}
+		if len(msg) > maxBERLength+6 {
+			t.Fatalf("readBER returned %d bytes, over the limit", len(msg))
+		}
+		if !bytes.HasPrefix(b, msg) {
+			t.Fatalf("readBER returned %x, not a prefix of its input %x", msg, b)
+		}
+	})
+}
@@ -0,0 +1,176 @@
+package main
+
+import "slices"
+
+// scanEvent is a message from a scan worker to the aggregator.
+type scanEvent interface {
+	scanEvent()
+}
+
+// hostUpEvent reports that a host answered discovery.
+type hostUpEvent struct {
+	Host HostResult
+}
+
+// hostDoneEvent reports that the port scan of a live host is finished;
+// scanned ports were probed.
+type hostDoneEvent struct {
+	IP      string
+	Scanned int
+}
+
+// hostDownEvent reports that a host did not answer discovery.
+type hostDownEvent struct {
+	IP string
+}
+
+// portResultEvent carries the outcome of one port probe.
+type portResultEvent struct {
+	IP     string
+	Result PortResult
+}
+
+// netbiosEvent carries the NetBIOS name a host reported.
+type netbiosEvent struct {
+	IP   string
+	Name string
+}
+
+// ptrEvent carries the name a reverse lookup of a host's address found.
+type ptrEvent struct {
+	IP   string
+	Name string
+}
+
+// errorEvent reports a probe that could not be carried out, as opposed to
+// one that found the host down or the port closed.
+type errorEvent struct {
+	IP   string
+	Port int // zero for host-level errors
+	Err  error
+}
+
+func (hostUpEvent) scanEvent()     {}
+func (hostDoneEvent) scanEvent()   {}
+func (hostDownEvent) scanEvent()   {}
+func (portResultEvent) scanEvent() {}
+func (netbiosEvent) scanEvent()    {}
+func (ptrEvent) scanEvent()        {}
+func (errorEvent) scanEvent()      {}
+
+// aggregator owns all scan results. Workers only send events; the state is
+// touched solely by the run goroutine until close returns, after which it
+// may be read freely.
+type aggregator struct {
+	events chan scanEvent
+	done   chan struct{}
+
+	hosts  []*HostResult // in the order they came up; callers sort them
+	byIP   map[string]*HostResult
+	errors []errorEvent
+	stats  *ScanStats
+	log    *eventLog     // if set, every event is appended to it
+	out    *resultStream // if set, each host is written to it when done
+
+	keepFiltered bool // keep filtered ports on their hosts, for ACK scans
+}
+
+// newAggregator starts an aggregator that already knows hosts, so port
+// results for them are attached to the existing HostResults, and that adds
+// its counts to stats. log and out may be nil. Results in listedStates are
+// kept on their hosts, filtered ones only if keepFiltered is set.
+func newAggregator(hosts []*HostResult, stats *ScanStats, log *eventLog, out *resultStream, keepFiltered bool) *aggregator {
+	a := &aggregator{
+		events:       make(chan scanEvent, 256),
+		done:         make(chan struct{}),
+		hosts:        hosts,
+		byIP:         make(map[string]*HostResult),
+		stats:        stats,
+		log:          log,
+		out:          out,
+		keepFiltered: keepFiltered,
+	}
+	for _, h := range hosts {
+		a.byIP[h.IP] = h
+	}
+	go a.run()
+	return a
+}
+
+func (a *aggregator) run() {
+	defer close(a.done)
+	for ev := range a.events {
+		if a.log != nil {
+			a.log.record(ev)
+		}
+		switch ev := ev.(type) {
+		case hostUpEvent:
+			if a.byIP[ev.Host.IP] == nil {
+				h := ev.Host
+				a.byIP[h.IP] = &h
+				a.hosts = append(a.hosts, &h)
+				a.stats.HostsUp++
+			}
+		case hostDoneEvent:
+			// The host's results are complete as far as the port scan
+			// goes; its honeypot signals and role can be judged.
+			if h := a.byIP[ev.IP]; h != nil {
+				h.sortPorts()
+				markHoneypot(h, ev.Scanned)
+				h.Role = inferRole(h)
+				if a.out != nil {
+					a.out.host(h)
+				}
+			}
+		case hostDownEvent:
+			a.stats.HostsDown++
+		case portResultEvent:
+			a.stats.PortsScanned++
+			switch ev.Result.State {
+			case "open":
+				a.stats.PortsOpen++
+			case "closed":
+				a.stats.PortsClosed++
+			case "filtered":
+				a.stats.PortsFiltered++
+			case "open|filtered":
+				a.stats.PortsOpenFiltered++
+			case "unfiltered":
+				a.stats.PortsUnfiltered++
+			}
+			keep := slices.Contains(listedStates, ev.Result.State) &&
+				(ev.Result.State != "filtered" || a.keepFiltered)
+			if h := a.byIP[ev.IP]; h != nil && keep {
+				h.Ports = append(h.Ports, ev.Result)
+			}
+		case netbiosEvent:
+			if h := a.byIP[ev.IP]; h != nil {
+				h.NetBIOS = ev.Name
+			}
+		case ptrEvent:
+			// A name the target was given as takes precedence.
+			if h := a.byIP[ev.IP]; h != nil && h.Hostname == "" {
+				h.Hostname = ev.Name
+			}
+		case errorEvent:
+			a.errors = append(a.errors, ev)
+			a.stats.Errors[errorClass(ev.Err)]++
+		}
+	}
+	for _, h := range a.hosts {
+		h.sortPorts()
+	}
+}
+
+// send delivers an event to the aggregator. It must not be called after
+// close.
+func (a *aggregator) send(ev scanEvent) {
+	a.events <- ev
+}
+
+// close stops accepting events and waits until all sent events have been
+// applied.
+func (a *aggregator) close() {
+	close(a.events)
+	<-a.done
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// errNoARP means ARP cannot be used to discover a target: it is not on a
+// directly attached IPv4 subnet, or the platform or privileges do not
+// allow it. Discovery then falls back to ICMP.
+var errNoARP = errors.New("ARP discovery not available")
+
+// arpInterface finds the Ethernet interface whose subnet contains ip, and
+// the interface's own address on that subnet.
+func arpInterface(ip net.IP) (*net.Interface, net.IP, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range ifaces {
+		iface := &ifaces[i]
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 || len(iface.HardwareAddr) != 6 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			n, ok := a.(*net.IPNet)
+			if !ok || n.IP.To4() == nil || !n.Contains(ip) {
+				continue
+			}
+			// The kernel does not answer ARP for its own address.
+			if n.IP.Equal(ip) {
+				return nil, nil, errNoARP
+			}
+			return iface, n.IP.To4(), nil
+		}
+	}
+	return nil, nil, errNoARP
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+)
+
+const ethPARP = 0x0806
+
+// arpPing asks for the hardware address of ip with an ARP request on the
+// attached subnet. A nil address without an error means nothing answered.
+// Hosts cannot filter ARP and still be reachable, so unlike ICMP this
+// finds every live host on the link.
+func arpPing(ip string, timeout time.Duration) (net.HardwareAddr, time.Duration, error) {
+	dst := net.ParseIP(ip).To4()
+	if dst == nil {
+		return nil, 0, errNoARP
+	}
+	iface, src, err := arpInterface(dst)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Datagram packet sockets take care of the Ethernet header.
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_DGRAM, int(htons(ethPARP)))
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %v", errNoARP, err)
+	}
+	defer syscall.Close(fd)
+	if err := syscall.Bind(fd, &syscall.SockaddrLinklayer{Protocol: htons(ethPARP), Ifindex: iface.Index}); err != nil {
+		return nil, 0, fmt.Errorf("binding ARP socket: %w", err)
+	}
+
+	req := make([]byte, 28)
+	binary.BigEndian.PutUint16(req[0:2], 1) // Ethernet
+	binary.BigEndian.PutUint16(req[2:4], syscall.ETH_P_IP)
+	req[4], req[5] = 6, 4
+	binary.BigEndian.PutUint16(req[6:8], 1) // request
+	copy(req[8:14], iface.HardwareAddr)
+	copy(req[14:18], src)
+	copy(req[24:28], dst)
+
+	broadcast := &syscall.SockaddrLinklayer{
+		Protocol: htons(ethPARP),
+		Ifindex:  iface.Index,
+		Halen:    6,
+		Addr:     [8]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+	}
+	probeRate.wait()
+	start := time.Now()
+	if err := syscall.Sendto(fd, req, 0, broadcast); err != nil {
+		return nil, 0, fmt.Errorf("sending ARP request: %w", err)
+	}
+	probeBytes.sent.Add(int64(len(req)))
+
+	// The socket sees all ARP traffic on the link, so wait for a reply
+	// from the target itself.
+	deadline := start.Add(timeout)
+	reply := make([]byte, 1500)
+	for {
+		left := time.Until(deadline)
+		if left <= 0 {
+			return nil, 0, nil
+		}
+		tv := syscall.NsecToTimeval(left.Nanoseconds())
+		if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+			return nil, 0, err
+		}
+		n, _, err := syscall.Recvfrom(fd, reply, 0)
+		if err == syscall.EAGAIN || err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("reading ARP reply: %w", err)
+		}
+		probeBytes.received.Add(int64(n))
+		if n < 28 || binary.BigEndian.Uint16(reply[6:8]) != 2 || !bytes.Equal(reply[14:18], dst) {
+			continue
+		}
+		return net.HardwareAddr(bytes.Clone(reply[8:14])), time.Since(start), nil
+	}
+}
+
+// htons converts a short to network byte order, as packet sockets expect
+// for protocol numbers.
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+	"net"
+	"time"
+)
+
+// arpPing is only implemented on Linux, using packet sockets; discovery
+// uses ICMP elsewhere.
+func arpPing(ip string, timeout time.Duration) (net.HardwareAddr, time.Duration, error) {
+	return nil, 0, errNoARP
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Finding is a risk found on one port that the report lists in a section
+// of its own rather than among the host's ports.
+type Finding struct {
+	IP       string   `json:"ip" xml:"ip,attr"`
+	Port     int      `json:"port" xml:"port,attr"`
+	Service  string   `json:"service" xml:"service,attr"`
+	Severity string   `json:"severity" xml:"severity,attr"` // "high" or "medium"
+	Detail   string   `json:"detail" xml:",chardata"`
+	Sources  []string `json:"sources,omitempty" xml:"source,omitempty"` // result files it came from, set by merge
+}
+
+// severityRank orders findings in the report, most severe first.
+var severityRank = map[string]int{"high": 0, "medium": 1}
+
+// rServices are the BSD r-commands. They are flagged on sight: they send
+// passwords, if they ask for one at all, and the session in cleartext.
+var rServices = map[int]string{512: "rexec", 513: "rlogin", 514: "rsh"}
+
+// ftpDeadline bounds the FTP greeting and AUTH TLS exchange.
+const ftpDeadline = 10 * time.Second
+
+// telnetIAC starts every telnet option negotiation.
+const telnetIAC = 0xff
+
+// cleartextPhase looks for services that take logins without encryption:
+// telnet, FTP without AUTH TLS, the r-commands and HTTP Basic
+// authentication on plain HTTP. Hosts that look like honeypots are left
+// out, as they are in the summary.
+type cleartextPhase struct{}
+
+func (cleartextPhase) Name() string { return "cleartext service check" }
+
+func (cleartextPhase) Run(s *Scan) error {
+	var mu sync.Mutex
+	s.probePorts(cleartextPhase{}, anyPort, func(h *HostResult, p *PortResult) error {
+		if f, ok := checkCleartext(h.IP, *p, s.Timeout); ok {
+			mu.Lock()
+			s.Findings = append(s.Findings, f)
+			mu.Unlock()
+		}
+		return nil
+	})
+	sortFindings(s.Findings)
+	return nil
+}
+
+// sortFindings orders findings by severity, then by address and port.
+func sortFindings(findings []Finding) {
+	slices.SortFunc(findings, func(a, b Finding) int {
+		return cmp.Or(
+			cmp.Compare(severityRank[a.Severity], severityRank[b.Severity]),
+			compareAddrs(a.IP, b.IP),
+			cmp.Compare(a.Port, b.Port))
+	})
+}
+
+// checkCleartext probes the open port p of ip for a cleartext login
+// service, choosing the check by port number and service name.
+func checkCleartext(ip string, p PortResult, timeout time.Duration) (Finding, bool) {
+	addr := net.JoinHostPort(ip, strconv.Itoa(p.Port))
+	f := Finding{IP: ip, Port: p.Port, Severity: "high"}
+	switch {
+	case rServices[p.Port] != "":
+		f.Service = rServices[p.Port]
+		f.Detail = "BSD r-command service: passwords, where asked for, and sessions travel in cleartext"
+		return f, true
+	case p.Port == 23 || p.Port == 2323:
+		if !isTelnet(addr, timeout) {
+			return f, false
+		}
+		f.Service = "telnet"
+		f.Detail = "telnet: logins and sessions travel in cleartext"
+		return f, true
+	case p.Port == 21:
+		tls, err := ftpAuthTLS(addr, timeout)
+		if err != nil {
+			return f, false
+		}
+		f.Service = "ftp"
+		f.Detail = "FTP without AUTH TLS: logins and files travel in cleartext"
+		if tls {
+			f.Severity = "medium"
+			f.Detail = "FTP offers AUTH TLS, but clients that do not ask for it log in in cleartext"
+		}
+		return f, true
+	case strings.HasPrefix(p.Service, "http") && !strings.HasPrefix(p.Service, "https"):
+		realm, ok := httpBasicAuth(addr, timeout)
+		if !ok {
+			return f, false
+		}
+		f.Service = "http"
+		f.Detail = fmt.Sprintf("HTTP Basic authentication without TLS (realm %q): passwords travel base64-encoded", realm)
+		return f, true
+	}
+	return f, false
+}
+
+// isTelnet reports whether the server at addr opens with a telnet option
+// negotiation, as telnet daemons do before the login prompt.
+func isTelnet(addr string, timeout time.Duration) bool {
+	probeRate.wait()
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 1)
+	n, _ := conn.Read(buf)
+	return n == 1 && buf[0] == telnetIAC
+}
+
+// ftpAuthTLS reads the greeting of the FTP server at addr and reports
+// whether it agrees to AUTH TLS. The connection is closed before the TLS
+// handshake; the answer is all that matters.
+func ftpAuthTLS(addr string, timeout time.Duration) (bool, error) {
+	probeRate.wait()
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(ftpDeadline))
+	text := textproto.NewConn(conn)
+	if _, _, err := text.ReadResponse(220); err != nil {
+		return false, fmt.Errorf("greeting: %w", err)
+	}
+	_, err = textCmd(text, 234, "AUTH TLS")
+	if _, ok := err.(*textproto.Error); ok {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// httpBasicAuth requests / from addr over plain HTTP and reports whether
+// the server asks for Basic authentication, and the realm it names.
+func httpBasicAuth(addr string, timeout time.Duration) (string, bool) {
+	probeRate.wait()
+	client := &http.Client{
+		Timeout:       timeout,
+		Transport:     &http.Transport{DisableKeepAlives: true},
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	resp, err := client.Get("http://" + addr + "/")
+	if err != nil {
+		return "", false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		return "", false
+	}
+	for _, challenge := range resp.Header.Values("WWW-Authenticate") {
+		scheme, params, _ := strings.Cut(challenge, " ")
+		if strings.EqualFold(scheme, "Basic") {
+			_, realm, _ := strings.Cut(params, "realm=")
+			realm, _, _ = strings.Cut(strings.Trim(realm, `"`), `"`)
+			return realm, true
+		}
+	}
+	return "", false
+}
+
+// printFindings writes the cleartext credentials section of the summary.
+func printFindings(w io.Writer, findings []Finding) {
+	if len(findings) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\nCleartext credentials risk (%d services):\n", len(findings))
+	for _, f := range findings {
+		fmt.Fprintf(w, "  [%s] %s port %d (%s): %s\n", f.Severity, f.IP, f.Port, f.Service, f.Detail)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// cloudFeeds are the published IP range documents of the supported cloud
+// providers. Azure publishes its service tags under a URL that changes with
+// every weekly release, so its document has to be downloaded by hand and
+// passed with -cloud-ranges.
+var cloudFeeds = map[string]string{
+	"aws":   "https://ip-ranges.amazonaws.com/ip-ranges.json",
+	"gcp":   "https://www.gstatic.com/ipranges/cloud.json",
+	"azure": "",
+}
+
+// cloudPrefix is one IPv4 block from a provider's range document.
+type cloudPrefix struct {
+	CIDR    string
+	Region  string
+	Service string
+}
+
+// newCloudProvider yields the addresses of a cloud provider's published
+// ranges selected by spec, "provider[:region[:service]]", e.g.
+// "aws:eu-west-1:EC2". Region and service match case-insensitively and may
+// be left empty to match all. The ranges are fetched fresh on every run
+// unless file names a previously downloaded copy.
+func newCloudProvider(spec, file string) (TargetProvider, error) {
+	parts := strings.SplitN(spec, ":", 3)
+	for len(parts) < 3 {
+		parts = append(parts, "")
+	}
+	provider, region, service := strings.ToLower(parts[0]), parts[1], parts[2]
+
+	feed, ok := cloudFeeds[provider]
+	if !ok {
+		return nil, fmt.Errorf("unknown cloud provider %q (want aws, gcp or azure)", provider)
+	}
+
+	var data []byte
+	var err error
+	switch {
+	case file != "":
+		data, err = os.ReadFile(file)
+	case feed == "":
+		return nil, fmt.Errorf("%s ranges cannot be fetched automatically; download the service tags JSON and pass it with -cloud-ranges", provider)
+	default:
+		data, err = fetchCloudRanges(feed)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	prefixes, err := parseCloudRanges(provider, data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s ranges: %w", provider, err)
+	}
+
+	var providers multiProvider
+	for _, p := range prefixes {
+		if region != "" && !strings.EqualFold(p.Region, region) {
+			continue
+		}
+		if service != "" && !strings.EqualFold(p.Service, service) {
+			continue
+		}
+		cidr, err := newCIDRProvider(p.CIDR)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, cidr)
+	}
+	if len(providers) == 0 {
+		return nil, fmt.Errorf("no %s ranges match %q", provider, spec)
+	}
+	return &providers, nil
+}
+
+func fetchCloudRanges(url string) ([]byte, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// parseCloudRanges extracts the IPv4 prefixes from a provider's document.
+func parseCloudRanges(provider string, data []byte) ([]cloudPrefix, error) {
+	var prefixes []cloudPrefix
+	switch provider {
+	case "aws":
+		var doc struct {
+			Prefixes []struct {
+				IPPrefix string `json:"ip_prefix"`
+				Region   string `json:"region"`
+				Service  string `json:"service"`
+			} `json:"prefixes"`
+		}
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+		for _, p := range doc.Prefixes {
+			prefixes = append(prefixes, cloudPrefix{p.IPPrefix, p.Region, p.Service})
+		}
+
+	case "gcp":
+		var doc struct {
+			Prefixes []struct {
+				IPv4Prefix string `json:"ipv4Prefix"`
+				Scope      string `json:"scope"`
+				Service    string `json:"service"`
+			} `json:"prefixes"`
+		}
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+		for _, p := range doc.Prefixes {
+			if p.IPv4Prefix != "" {
+				prefixes = append(prefixes, cloudPrefix{p.IPv4Prefix, p.Scope, p.Service})
+			}
+		}
+
+	case "azure":
+		var doc struct {
+			Values []struct {
+				Properties struct {
+					Region          string   `json:"region"`
+					SystemService   string   `json:"systemService"`
+					AddressPrefixes []string `json:"addressPrefixes"`
+				} `json:"properties"`
+			} `json:"values"`
+		}
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+		for _, v := range doc.Values {
+			for _, cidr := range v.Properties.AddressPrefixes {
+				if !strings.Contains(cidr, ":") {
+					prefixes = append(prefixes, cloudPrefix{cidr, v.Properties.Region, v.Properties.SystemService})
+				}
+			}
+		}
+	}
+	return prefixes, nil
+}
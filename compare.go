@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// parseTargetGroup turns a comma-separated list of target specifications
+// into one provider.
+func parseTargetGroup(list string) (TargetProvider, error) {
+	var group multiProvider
+	for _, spec := range strings.Split(list, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		p, err := parseTarget(spec)
+		if err != nil {
+			return nil, err
+		}
+		group = append(group, p)
+	}
+	if len(group) == 0 {
+		return nil, fmt.Errorf("empty target group %q", list)
+	}
+	return &group, nil
+}
+
+// runCompare scans two target groups with the settings of base and reports
+// which open ports differ between them, e.g. services open in production
+// but not in staging.
+func runCompare(base *Scan, groupA, groupB string) error {
+	phases := []Phase{expandPhase{}, hostScanPhase{}}
+	var results [2]map[int][]string
+	for i, list := range []string{groupA, groupB} {
+		targets, err := parseTargetGroup(list)
+		if err != nil {
+			return err
+		}
+		s := *base
+		s.Targets = targets
+		fmt.Fprintf(console, "Scanning group %c (%s)\n", 'A'+i, list)
+		if err := s.Run(phases); err != nil {
+			return err
+		}
+		results[i] = openPortHosts(s.Hosts)
+	}
+
+	printDrift(console, groupA, groupB, results[0], results[1])
+	return nil
+}
+
+// openPortHosts maps each open port, or open|filtered one with a stealth
+// scan type, to the hosts it is open on.
+func openPortHosts(hosts []*HostResult) map[int][]string {
+	m := make(map[int][]string)
+	for _, h := range hosts {
+		if h.quarantined() {
+			continue
+		}
+		for _, port := range h.PortsIn("open", "open|filtered") {
+			m[port] = append(m[port], h.IP)
+		}
+	}
+	return m
+}
+
+func printDrift(w io.Writer, groupA, groupB string, a, b map[int][]string) {
+	var onlyA, onlyB, both []int
+	for port := range a {
+		if _, ok := b[port]; ok {
+			both = append(both, port)
+		} else {
+			onlyA = append(onlyA, port)
+		}
+	}
+	for port := range b {
+		if _, ok := a[port]; !ok {
+			onlyB = append(onlyB, port)
+		}
+	}
+	sort.Ints(onlyA)
+	sort.Ints(onlyB)
+	sort.Ints(both)
+
+	fmt.Fprintf(w, "\nDrift between group A (%s) and group B (%s):\n", groupA, groupB)
+	if len(onlyA) == 0 && len(onlyB) == 0 {
+		fmt.Fprintln(w, "No drift: the same ports are open in both groups")
+	}
+	for _, port := range onlyA {
+		fmt.Fprintf(w, "Port %d is open only in group A, on %s\n", port, strings.Join(a[port], ", "))
+	}
+	for _, port := range onlyB {
+		fmt.Fprintf(w, "Port %d is open only in group B, on %s\n", port, strings.Join(b[port], ", "))
+	}
+	if len(both) > 0 {
+		fmt.Fprintf(w, "Open in both groups: %v\n", both)
+	}
+}
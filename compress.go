@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdMagic starts every zstd frame.
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// createFile creates name for writing, zstd-compressing what is written
+// when the name ends in ".zst".
+func createFile(name string) (io.WriteCloser, error) {
+	f, err := os.Create(name)
+	if err != nil || !strings.HasSuffix(name, ".zst") {
+		return f, err
+	}
+	enc, err := zstd.NewWriter(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &zstdFile{enc, f}, nil
+}
+
+// appendFile opens name for appending, creating it if needed, like
+// createFile. Appending to a compressed file starts a new zstd frame,
+// which openFile reads as a continuation of the earlier ones.
+func appendFile(name string) (io.WriteCloser, error) {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil || !strings.HasSuffix(name, ".zst") {
+		return f, err
+	}
+	enc, err := zstd.NewWriter(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &zstdFile{enc, f}, nil
+}
+
+// zstdFile flushes the compressor before closing the file under it.
+type zstdFile struct {
+	*zstd.Encoder
+	f *os.File
+}
+
+func (z *zstdFile) Close() error {
+	err := z.Encoder.Close()
+	if cerr := z.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// openFile opens a result file for reading, decompressing it if it is
+// zstd-compressed whatever its name.
+func openFile(name string) (io.ReadCloser, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(f)
+	if magic, _ := r.Peek(len(zstdMagic)); !bytes.Equal(magic, zstdMagic) {
+		return struct {
+			io.Reader
+			io.Closer
+		}{r, f}, nil
+	}
+	dec, err := zstd.NewReader(r)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{dec, closerFunc(func() error {
+		dec.Close()
+		return f.Close()
+	})}, nil
+}
+
+type closerFunc func() error
+
+func (c closerFunc) Close() error { return c() }
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// discoveryProbe is one way of checking whether a host is up.
+type discoveryProbe struct {
+	kind  string // "icmp", "syn" or "ack"
+	ports []int  // TCP ports, for syn and ack
+}
+
+// defaultPingPorts are probed by syn and ack entries that list no ports.
+var defaultPingPorts = []int{80, 443}
+
+// parseDiscovery parses a -discovery list such as "icmp,syn:80,443,ack:80".
+// Bare port numbers belong to the TCP probe before them.
+func parseDiscovery(spec string) ([]discoveryProbe, error) {
+	var probes []discoveryProbe
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if port, err := strconv.Atoi(field); err == nil {
+			last := len(probes) - 1
+			if last < 0 || probes[last].kind == "icmp" {
+				return nil, fmt.Errorf("port %d does not follow a syn or ack probe", port)
+			}
+			if port < 1 || port > 65535 {
+				return nil, fmt.Errorf("invalid port %d", port)
+			}
+			probes[last].ports = append(probes[last].ports, port)
+			continue
+		}
+		kind, port, hasPort := strings.Cut(field, ":")
+		switch kind {
+		case "icmp":
+			if hasPort {
+				return nil, fmt.Errorf("icmp probes take no port: %q", field)
+			}
+			probes = append(probes, discoveryProbe{kind: kind})
+		case "syn", "ack":
+			p := discoveryProbe{kind: kind}
+			if hasPort {
+				n, err := parsePort(port)
+				if err != nil {
+					return nil, err
+				}
+				p.ports = []int{n}
+			}
+			probes = append(probes, p)
+		default:
+			return nil, fmt.Errorf("unknown discovery probe %q (want icmp, syn or ack)", kind)
+		}
+	}
+	if len(probes) == 0 {
+		return nil, fmt.Errorf("no discovery probes in %q", spec)
+	}
+	for i := range probes {
+		if probes[i].kind != "icmp" && len(probes[i].ports) == 0 {
+			probes[i].ports = defaultPingPorts
+		}
+	}
+	return probes, nil
+}
+
+// withoutICMP drops the ICMP probes, for when no ICMP socket can be
+// opened, falling back to a TCP ping of the default ports if that leaves
+// none. TCP pings work unprivileged, see tcpPing.
+func withoutICMP(probes []discoveryProbe) []discoveryProbe {
+	var tcp []discoveryProbe
+	for _, p := range probes {
+		if p.kind != "icmp" {
+			tcp = append(tcp, p)
+		}
+	}
+	if len(tcp) == 0 {
+		tcp = []discoveryProbe{{kind: "syn", ports: defaultPingPorts}}
+	}
+	return tcp
+}
+
+// probeCount is the number of packets probes send to each host.
+func probeCount(probes []discoveryProbe) int {
+	n := 0
+	for _, p := range probes {
+		n += max(len(p.ports), 1)
+	}
+	return n
+}
+
+// discoverHost runs the probes against ip in order until one gets an
+// answer, filling in h. ARP replaces them on directly attached subnets.
+func discoverHost(h *HostResult, probes []discoveryProbe, timeout time.Duration) (bool, error) {
+	mac, rtt, err := arpPing(h.IP, timeout)
+	if !errors.Is(err, errNoARP) {
+		h.Method, h.RTT = "arp", rtt
+		if mac != nil {
+			h.MAC = mac.String()
+		}
+		return mac != nil, err
+	}
+
+	var firstErr error
+	for _, p := range probes {
+		if p.kind == "icmp" {
+			probeRate.wait()
+			up, rtt, err := pingHost(h.IP, timeout)
+			if up {
+				h.Method, h.RTT = "icmp", rtt
+				return true, nil
+			}
+			firstErr = cmp.Or(firstErr, err)
+			continue
+		}
+		for _, port := range p.ports {
+			probeRate.wait()
+			up, rtt, err := tcpPing(h.IP, port, p.kind, timeout)
+			if up {
+				h.Method, h.RTT = p.kind+"/"+strconv.Itoa(port), rtt
+				return true, nil
+			}
+			firstErr = cmp.Or(firstErr, err)
+		}
+	}
+	return false, firstErr
+}
+
+// rawTCPAvailable reports, once, whether TCP pings can use raw sockets.
+var rawTCPAvailable = sync.OnceValue(func() bool { return checkRawTCP() == nil })
+
+// tcpPing reports whether ip answers a TCP probe to port. Any answer, even
+// a RST, shows the host is up. Without raw sockets, and for IPv6, both
+// kinds fall back to a connect attempt, where a refusal counts as an
+// answer.
+func tcpPing(ip string, port int, kind string, timeout time.Duration) (bool, time.Duration, error) {
+	if rawTCPAvailable() && net.ParseIP(ip).To4() != nil {
+		flags := byte(tcpSYN)
+		if kind == "ack" {
+			flags = tcpACK
+		}
+		reply, rtt, err := rawTCPProbe(ip, port, flags, timeout)
+		return reply != 0, rtt, err
+	}
+
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip, strconv.Itoa(port)), timeout)
+	rtt := time.Since(start)
+	if err == nil {
+		conn.Close()
+		return true, rtt, nil
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return true, rtt, nil
+	}
+	if isLocalError(err) {
+		return false, 0, err
+	}
+	return false, 0, nil
+}
+
+// announcements holds what devices on the local network said about
+// themselves in answer to the multicast sweeps.
+type announcements struct {
+	mdns map[string]*mdnsHost
+	upnp map[string]string // description URL by address
+}
+
+// sweepLocal runs the enabled multicast sweeps. A failed sweep is reported
+// and skipped; the regular probes still run.
+func (s *Scan) sweepLocal() announcements {
+	var a announcements
+	wait := max(s.Timeout, time.Second)
+	if s.MDNS {
+		var err error
+		if a.mdns, err = mdnsSweep(wait); err != nil {
+			fmt.Fprintf(console, "mDNS discovery failed: %v\n", err)
+		} else {
+			fmt.Fprintf(console, "%d devices answered mDNS queries\n", len(a.mdns))
+		}
+	}
+	if s.SSDP {
+		var err error
+		if a.upnp, err = ssdpSweep(wait); err != nil {
+			fmt.Fprintf(console, "SSDP discovery failed: %v\n", err)
+		} else {
+			fmt.Fprintf(console, "%d devices answered SSDP searches\n", len(a.upnp))
+		}
+	}
+	return a
+}
+
+// apply fills in what h announced and reports whether it announced
+// anything, which shows it is up.
+func (a announcements) apply(h *HostResult, timeout time.Duration) bool {
+	m, loc := a.mdns[h.IP], a.upnp[h.IP]
+	if m != nil {
+		if h.Hostname == "" {
+			h.Hostname = m.Hostname
+		}
+		h.Method, h.Names = "mdns", m.Names
+	}
+	if loc != "" {
+		if h.Method == "" {
+			h.Method = "ssdp"
+		}
+		// Only follow descriptions served by the device itself, so a
+		// response cannot point the scanner at other hosts.
+		if u, err := url.Parse(loc); err == nil && u.Hostname() == h.IP {
+			if d, err := fetchUPnPDevice(loc, timeout); err == nil {
+				h.Device = d.String()
+			}
+		}
+	}
+	return m != nil || loc != ""
+}
+
+// attachedNets returns the subnets of the local interfaces, loopback
+// included.
+func attachedNets() []*net.IPNet {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	var nets []*net.IPNet
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok {
+			nets = append(nets, n)
+		}
+	}
+	return nets
+}
+
+// scheduleRemoteFirst moves the targets outside the attached subnets
+// ahead of those inside, keeping the order within each group, and
+// returns how many are remote. Remote targets take a round trip across
+// the WAN to answer or a full timeout not to, so starting them first
+// lets the quick local ones fill the pool around them instead of a few
+// slow ones holding up the end of the scan.
+func scheduleRemoteFirst(targets []Target, local []*net.IPNet) int {
+	var remote, near []Target
+	for _, t := range targets {
+		ip := net.ParseIP(t.IP)
+		if slices.ContainsFunc(local, func(n *net.IPNet) bool { return n.Contains(ip) }) {
+			near = append(near, t)
+		} else {
+			remote = append(remote, t)
+		}
+	}
+	copy(targets[copy(targets, remote):], near)
+	return len(remote)
+}
@@ -0,0 +1,508 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"math/rand/v2"
+	"runtime/debug"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Scan holds the configuration of a run and the state each phase hands to
+// the next.
+type Scan struct {
+	Targets   TargetProvider
+	Exclude   exclusionList
+	Ports     []int
+	Timeout   time.Duration
+	MaxProbes uint64
+	Confirm   bool
+	Output    io.Writer        // structured results as JSON lines, if set
+	Log       *eventLog        // checksummed event log, if set
+	Bundle    string           // base name for -oA output files, if set
+	Compress  bool             // zstd-compress the -oA files
+	DumpStack bool             // print stack traces of recovered probe panics
+	Randomize bool             // probe targets and ports in random order
+	Seed      uint64           // seeds the Randomize shuffles, see Scan.rng
+	ScanType  string           // key of scanTypes; connect scan if empty
+	Discovery []discoveryProbe // ICMP echo only if empty
+	NoPing    bool             // treat every target as up, as with -Pn
+	MDNS      bool             // also discover hosts and names with mDNS
+	SSDP      bool             // also discover UPnP devices with SSDP
+	NetBIOS   bool             // query live hosts for their NetBIOS names
+	RDNS      bool             // look up the names of live hosts with PTR queries
+	VHosts    []string         // candidate virtual host names, see vhostPhase
+	Retries   int              // extra attempts for probes that got no answer
+	Adaptive  bool             // derive port probe timeouts from each host's RTT
+	RelayTest bool             // let the SMTP audit test for open relays
+	MinRate   float64          // probes per second to sustain, sizes the worker pools
+	Workers   int              // port probes in flight at once; defaultWorkers if zero
+	HostLimit int              // hosts discovered or scanned at once; discoveryWorkers if zero
+	PortLimit int              // port probes in flight per host; no limit but Workers if zero
+	AD        bool             // summarise the AD domains of domain controllers found
+	Budget    time.Duration    // time each probePorts phase may take; no limit if zero
+
+	Expanded []Target      // filled by target expansion
+	Hosts    []*HostResult // live hosts by address, filled by discovery
+	Errors   []errorEvent  // probes that could not be carried out
+	Findings []Finding     // cleartext login services, most severe first
+	Domains  []ADDomain    // Active Directory domains, filled if AD is set
+	Families []FamilyDiff  // ports open over IPv4 or IPv6 only, by hostname
+	Stats    ScanStats
+
+	stream *resultStream // writes to Output, see results
+}
+
+// results returns the stream writing to Output, starting it on first use,
+// or nil if Output is not set.
+func (s *Scan) results() *resultStream {
+	if s.stream == nil && s.Output != nil {
+		s.stream = newResultStream(s.Output)
+	}
+	return s.stream
+}
+
+// Phase is one step of a scan. Phases run in order and communicate only
+// through the Scan, so a new step such as service detection is added by
+// inserting it into the list returned by defaultPhases.
+type Phase interface {
+	Name() string
+	Run(s *Scan) error
+}
+
+func defaultPhases() []Phase {
+	return []Phase{
+		expandPhase{},
+		hostScanPhase{},
+		vhostPhase{},
+		smtpPhase{},
+		cleartextPhase{},
+		adPhase{},
+		familyPhase{},
+		reportPhase{},
+	}
+}
+
+// Run executes phases in order, stopping at the first that fails.
+func (s *Scan) Run(phases []Phase) error {
+	s.Stats.Errors = make(map[string]int)
+	for _, phase := range phases {
+		start := time.Now()
+		err := phase.Run(s)
+		s.Stats.Phases = append(s.Stats.Phases, PhaseTiming{Name: phase.Name(), Duration: time.Since(start)})
+		if err != nil {
+			return fmt.Errorf("%s: %w", phase.Name(), err)
+		}
+	}
+	return nil
+}
+
+func (s *Scan) discoveryProbes() []discoveryProbe {
+	if len(s.Discovery) == 0 {
+		return []discoveryProbe{{kind: "icmp"}}
+	}
+	return s.Discovery
+}
+
+// safeProbe runs probe and converts a panic inside it into an error, so a
+// bug triggered by one target becomes an error result for that target
+// instead of ending the whole scan.
+func (s *Scan) safeProbe(probe func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("probe panicked: %v", r)
+			if s.DumpStack {
+				fmt.Fprintf(console, "%v\n%s", err, debug.Stack())
+			}
+		}
+	}()
+	return probe()
+}
+
+// phaseFailureLimit is how many probes of one phase may fail, panics
+// included, before probePorts switches the phase off.
+const phaseFailureLimit = 5
+
+// probePorts calls probe for every open port, of the hosts that are not
+// quarantined, that accept allows, on a pool of Workers goroutines like
+// the port scan's, and waits for the calls.
+// Errors from probe, panics included, become error results for the port
+// just as during the port scan. probe may change the port it is given but
+// must guard anything else it shares.
+// Each call of probe is bounded by the deadlines of its protocol; the
+// phase as a whole by Budget, after which the remaining ports are
+// skipped, and by phaseFailureLimit, after which phase is switched off
+// for the rest of the scan. Either is reported on the console.
+func (s *Scan) probePorts(phase Phase, accept func(h *HostResult, p *PortResult) bool, probe func(h *HostResult, p *PortResult) error) {
+	type job struct {
+		h *HostResult
+		p *PortResult
+	}
+	var todo []job
+	for _, h := range s.Hosts {
+		if h.quarantined() {
+			continue
+		}
+		for i := range h.Ports {
+			if p := &h.Ports[i]; p.State == "open" && accept(h, p) {
+				todo = append(todo, job{h, p})
+			}
+		}
+	}
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		errs     []errorEvent
+		skipped  int
+		deadline time.Time
+	)
+	if s.Budget > 0 {
+		deadline = time.Now().Add(s.Budget)
+	}
+	jobs := make(chan job)
+	for range min(cmp.Or(s.Workers, defaultWorkers), len(todo)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				mu.Lock()
+				skip := len(errs) >= phaseFailureLimit || !deadline.IsZero() && time.Now().After(deadline)
+				if skip {
+					skipped++
+				}
+				mu.Unlock()
+				if skip {
+					continue
+				}
+				if err := s.safeProbe(func() error { return probe(j.h, j.p) }); err != nil {
+					mu.Lock()
+					errs = append(errs, errorEvent{IP: j.h.IP, Port: j.p.Port, Err: err})
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, j := range todo {
+		jobs <- j
+	}
+	close(jobs)
+	wg.Wait()
+	switch {
+	case len(errs) >= phaseFailureLimit && skipped > 0:
+		fmt.Fprintf(console, "Warning: %s failed on %d ports; switched it off, skipping %d more\n", phase.Name(), len(errs), skipped)
+	case skipped > 0:
+		fmt.Fprintf(console, "Warning: %s ran out of its -phase-timeout of %v, skipping %d of %d ports\n", phase.Name(), s.Budget, skipped, len(todo))
+	}
+	for _, e := range errs {
+		s.Errors = append(s.Errors, e)
+		s.Stats.Errors[errorClass(e.Err)]++
+	}
+}
+
+// anyPort is the accept function of probePorts for phases that look at
+// every open port.
+func anyPort(*HostResult, *PortResult) bool { return true }
+
+// expandPhase drains the target providers into a list of addresses,
+// dropping excluded ones, and stops as soon as the probe count exceeds the
+// safety cap.
+type expandPhase struct{}
+
+func (expandPhase) Name() string { return "target expansion" }
+
+func (expandPhase) Run(s *Scan) error {
+	// The discovery probes plus one probe per port for each host, each sent
+	// up to 1+Retries times when it gets no answer.
+	perHost := uint64(len(s.Ports))
+	if !s.NoPing {
+		perHost += uint64(probeCount(s.discoveryProbes()))
+	}
+	perHost *= uint64(1 + s.Retries)
+	seen := make(map[string]int) // address to index in s.Expanded
+	for {
+		t, err := s.Targets.Next()
+		if err == io.EOF {
+			s.Stats.TargetsExpanded = len(s.Expanded)
+			if s.Randomize {
+				fmt.Fprintf(console, "Randomizing probe order with -seed %d\n", s.Seed)
+				s.rng("").Shuffle(len(s.Expanded), func(i, j int) {
+					s.Expanded[i], s.Expanded[j] = s.Expanded[j], s.Expanded[i]
+				})
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if s.Exclude.contains(t.IP) {
+			continue
+		}
+		// Overlapping sources name the same address more than once; scan it
+		// once, keeping a hostname if any source gave one.
+		if i, ok := seen[t.IP]; ok {
+			if s.Expanded[i].Name == "" {
+				s.Expanded[i].Name = t.Name
+			}
+			continue
+		}
+		seen[t.IP] = len(s.Expanded)
+		s.Expanded = append(s.Expanded, t)
+		if probes := uint64(len(s.Expanded)) * perHost; probes > s.MaxProbes && !s.Confirm {
+			return fmt.Errorf("scan would send more than %d probes (%d+ hosts x %d ports x %d attempts), over the -max-probes cap; "+
+				"check the targets, or re-run with -confirm to scan anyway",
+				s.MaxProbes, len(s.Expanded), len(s.Ports), 1+s.Retries)
+		}
+	}
+}
+
+// discoveryWorkers bounds how many targets are discovered or have their
+// ports scanned at once when -host-parallelism is not given.
+const discoveryWorkers = 64
+
+// defaultWorkers bounds the port probes and name lookups in flight when
+// -workers is not given. Each holds a socket, so together with discovery
+// they stay well below the usual limit of 1024 open files.
+const defaultWorkers = 512
+
+// hostScanPhase finds the live targets and probes every configured port on
+// each. Discovery runs on one pool of workers and feeds the probes of each
+// host it finds up to a second, fixed-size pool, so the ports are scanned
+// as soon as the host is found rather than after the whole range was
+// pinged, without a goroutine and socket per port of the range. A
+// discovery worker stays with its host until the host's probes are done,
+// so the first pool also bounds how many hosts are in progress.
+type hostScanPhase struct{}
+
+func (hostScanPhase) Name() string { return "discovery and port scan" }
+
+func (hostScanPhase) Run(s *Scan) error {
+	agg := newAggregator(s.Hosts, &s.Stats, s.Log, s.results(), s.ScanType == "ack")
+	s.Stats.HostsProbed += len(s.Expanded)
+	announced := s.sweepLocal()
+	if remote := scheduleRemoteFirst(s.Expanded, attachedNets()); remote > 0 && remote < len(s.Expanded) {
+		fmt.Fprintf(console, "Scheduling %d remote targets ahead of %d on attached subnets\n", remote, len(s.Expanded)-remote)
+	}
+
+	// A worker has at most one probe out per timeout, so sustaining
+	// MinRate takes MinRate x timeout of them.
+	need := int(math.Ceil(s.MinRate * s.Timeout.Seconds()))
+	start, sent := time.Now(), probeRate.probes.Load()
+
+	var probers sync.WaitGroup
+	jobs := make(chan func())
+	for range max(cmp.Or(s.Workers, defaultWorkers), need) {
+		probers.Add(1)
+		go func() {
+			defer probers.Done()
+			for job := range jobs {
+				job()
+			}
+		}()
+	}
+
+	var workers sync.WaitGroup
+	targets := make(chan Target)
+	for range min(max(cmp.Or(s.HostLimit, discoveryWorkers), need), len(s.Expanded)) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for t := range targets {
+				if host, up := s.discover(agg, t, announced); up {
+					s.scanHost(agg, host, jobs)
+					agg.send(hostDoneEvent{IP: host.IP, Scanned: len(s.Ports)})
+				}
+			}
+		}()
+	}
+	for _, t := range s.Expanded {
+		targets <- t
+	}
+	close(targets)
+	workers.Wait()
+	close(jobs)
+	probers.Wait()
+	agg.close()
+
+	if rate := float64(probeRate.probes.Load()-sent) / time.Since(start).Seconds(); rate < s.MinRate {
+		fmt.Fprintf(console, "Sent %.0f probes/s, below -min-rate %g: too few targets and ports to keep that many in flight\n", rate, s.MinRate)
+	}
+
+	s.Hosts = agg.hosts
+	sortHosts(s.Hosts)
+	s.Errors = append(s.Errors, agg.errors...)
+	return nil
+}
+
+// discover checks whether t is up: with ARP on directly attached subnets,
+// where it is available, from what it announced in the multicast sweeps,
+// or with the configured discovery probes otherwise.
+func (s *Scan) discover(agg *aggregator, t Target, announced announcements) (HostResult, bool) {
+	host := HostResult{IP: t.IP, Hostname: t.Name}
+	if s.NoPing {
+		host.Method = "none"
+		agg.send(hostUpEvent{Host: host})
+		return host, true
+	}
+	up := announced.apply(&host, s.Timeout)
+	if !up {
+		err := s.safeProbe(func() (err error) {
+			up, err = discoverHost(&host, s.discoveryProbes(), s.Timeout)
+			for retry := 0; retry < s.Retries && err == nil && !up; retry++ {
+				up, err = discoverHost(&host, s.discoveryProbes(), s.Timeout)
+			}
+			return err
+		})
+		if err != nil {
+			agg.send(errorEvent{IP: t.IP, Err: err})
+		}
+	}
+	if up {
+		fmt.Fprintf(console, "Host %s is up\n", t.IP)
+		agg.send(hostUpEvent{Host: host})
+	} else {
+		fmt.Fprintf(console, "Host %s is down, skipping...\n", t.IP)
+		agg.send(hostDownEvent{IP: t.IP})
+	}
+	return host, up
+}
+
+// rng returns the random source for the shuffles of one stream: the
+// target list for "", the ports of a host for its address. Each stream is
+// drawn from Seed alone, so a seed repeats the probe order of a scan
+// however its hosts are scheduled.
+func (s *Scan) rng(stream string) *rand.Rand {
+	h := fnv.New64a()
+	h.Write([]byte(stream))
+	return rand.New(rand.NewPCG(s.Seed, h.Sum64()))
+}
+
+// scanHost runs the port probes and name lookups for a live host on the
+// probe pool behind jobs, at most PortLimit at a time if it is set, and
+// waits until they are done.
+func (s *Scan) scanHost(agg *aggregator, h HostResult, jobs chan<- func()) {
+	var wg sync.WaitGroup
+	var slots chan struct{}
+	if s.PortLimit > 0 {
+		slots = make(chan struct{}, s.PortLimit)
+	}
+	queue := func(job func()) {
+		wg.Add(1)
+		if slots != nil {
+			slots <- struct{}{}
+		}
+		jobs <- func() {
+			defer wg.Done()
+			if slots != nil {
+				defer func() { <-slots }()
+			}
+			job()
+		}
+	}
+	defer wg.Wait()
+
+	probe := scanTypes[s.ScanType]
+	if probe == nil {
+		probe = scanPort
+	}
+	timeout := s.hostTimeout(&h)
+	fmt.Fprintf(console, "Scanning ports on %s...\n", h.IP)
+	ports := s.Ports
+	if s.Randomize {
+		ports = slices.Clone(ports)
+		s.rng(h.IP).Shuffle(len(ports), func(i, j int) { ports[i], ports[j] = ports[j], ports[i] })
+	}
+	ip := h.IP
+	if s.RDNS && h.Hostname == "" {
+		queue(func() {
+			probeRate.wait()
+			if name := lookupAddr(ip); name != "" {
+				agg.send(ptrEvent{IP: ip, Name: name})
+			}
+		})
+	}
+	if s.NetBIOS {
+		queue(func() {
+			var name string
+			err := s.safeProbe(func() (err error) {
+				probeRate.wait()
+				name, err = netbiosName(ip, timeout)
+				return err
+			})
+			if err == nil && name != "" {
+				agg.send(netbiosEvent{IP: ip, Name: name})
+			}
+		})
+	}
+	for _, port := range ports {
+		queue(func() {
+			var result PortResult
+			err := s.safeProbe(func() (err error) {
+				probeRate.wait()
+				result, err = probe(ip, port, timeout)
+				for retry := 0; retry < s.Retries && err == nil && unanswered(result.State); retry++ {
+					probeRate.wait()
+					result, err = probe(ip, port, timeout)
+				}
+				return err
+			})
+			if err != nil {
+				agg.send(errorEvent{IP: ip, Port: port, Err: err})
+				return
+			}
+			agg.send(portResultEvent{IP: ip, Result: result})
+		})
+	}
+}
+
+// minAdaptiveTimeout is the shortest timeout -adaptive-timeout will use,
+// so jitter on a fast LAN does not turn open ports into filtered ones.
+const minAdaptiveTimeout = 100 * time.Millisecond
+
+// hostTimeout is the timeout for probing h's ports: with Adaptive, four
+// times the RTT measured by discovery, kept between minAdaptiveTimeout
+// and the configured timeout; otherwise just the configured timeout.
+func (s *Scan) hostTimeout(h *HostResult) time.Duration {
+	if !s.Adaptive || h.RTT == 0 {
+		return s.Timeout
+	}
+	return min(max(4*h.RTT, minAdaptiveTimeout), s.Timeout)
+}
+
+// unanswered reports whether a port state means the probe got no answer.
+func unanswered(state string) bool {
+	return state == "filtered" || state == "open|filtered"
+}
+
+// reportPhase prints the summary and writes structured results.
+type reportPhase struct{}
+
+func (reportPhase) Name() string { return "report" }
+
+func (reportPhase) Run(s *Scan) error {
+	s.Stats.BytesSent = probeBytes.sent.Load()
+	s.Stats.BytesReceived = probeBytes.received.Load()
+	for _, h := range s.Hosts {
+		h.Role = inferRole(h)
+	}
+
+	printSummary(console, s.Hosts, s.Errors)
+	printFindings(console, s.Findings)
+	printDomains(console, s.Domains)
+	printFamilyDiffs(console, s.Families)
+	printStats(console, &s.Stats)
+	if out := s.results(); out != nil {
+		if err := out.finish(s); err != nil {
+			return fmt.Errorf("writing results: %w", err)
+		}
+	}
+	if s.Bundle != "" {
+		return writeBundle(s.Bundle, s)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRngRepeatsWithSeed(t *testing.T) {
+	shuffled := func(seed uint64, stream string) []int {
+		p := make([]int, 100)
+		for i := range p {
+			p[i] = i
+		}
+		s := &Scan{Seed: seed}
+		s.rng(stream).Shuffle(len(p), func(i, j int) { p[i], p[j] = p[j], p[i] })
+		return p
+	}
+	if a, b := shuffled(42, "10.0.0.1"), shuffled(42, "10.0.0.1"); !slices.Equal(a, b) {
+		t.Errorf("seed 42 shuffled the same stream differently: %v and %v", a, b)
+	}
+	if a, b := shuffled(42, "10.0.0.1"), shuffled(42, "10.0.0.2"); slices.Equal(a, b) {
+		t.Errorf("seed 42 shuffled the ports of two hosts the same way: %v", a)
+	}
+	if a, b := shuffled(42, ""), shuffled(43, ""); slices.Equal(a, b) {
+		t.Errorf("seeds 42 and 43 shuffled the targets the same way: %v", a)
+	}
+}
+
+// probeScan is a scan of one host with n open ports, probed one at a time.
+func probeScan(n int) *Scan {
+	h := &HostResult{IP: "10.0.0.1"}
+	for port := 1; port <= n; port++ {
+		h.Ports = append(h.Ports, PortResult{Port: port, State: "open"})
+	}
+	return &Scan{Hosts: []*HostResult{h}, Workers: 1, Stats: ScanStats{Errors: map[string]int{}}}
+}
+
+func TestProbePortsSwitchesOffFailingPhase(t *testing.T) {
+	s := probeScan(20)
+	calls := 0
+	s.probePorts(smtpPhase{}, anyPort, func(*HostResult, *PortResult) error {
+		calls++
+		panic("malformed reply")
+	})
+	if calls != phaseFailureLimit || len(s.Errors) != phaseFailureLimit {
+		t.Errorf("%d probes ran and %d failed, want the phase switched off after %d", calls, len(s.Errors), phaseFailureLimit)
+	}
+}
+
+func TestProbePortsBudget(t *testing.T) {
+	s := probeScan(5)
+	s.Budget = 10 * time.Millisecond
+	calls := 0
+	s.probePorts(vhostPhase{}, anyPort, func(*HostResult, *PortResult) error {
+		calls++
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	})
+	if calls >= 5 {
+		t.Errorf("all %d probes ran, want the 10ms budget to skip some", calls)
+	}
+}
+
+func TestScheduleRemoteFirst(t *testing.T) {
+	_, lan, _ := net.ParseCIDR("192.168.1.0/24")
+	_, lo, _ := net.ParseCIDR("127.0.0.0/8")
+	targets := []Target{
+		{IP: "192.168.1.10"}, {IP: "8.8.8.8"}, {IP: "127.0.0.1"},
+		{IP: "192.168.1.11"}, {IP: "2001:db8::1"}, {IP: "10.9.0.1"},
+	}
+	remote := scheduleRemoteFirst(targets, []*net.IPNet{lan, lo})
+	var got []string
+	for _, t := range targets {
+		got = append(got, t.IP)
+	}
+	want := []string{"8.8.8.8", "2001:db8::1", "10.9.0.1", "192.168.1.10", "127.0.0.1", "192.168.1.11"}
+	if remote != 3 || !slices.Equal(got, want) {
+		t.Errorf("scheduled %v with %d remote, want %v with 3", got, remote, want)
+	}
+}
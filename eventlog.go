@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"time"
+)
+
+// logEntry is one line of the event log. Sum, always the last field, is
+// the SHA-256 of the previous line's Sum followed by this line without
+// Sum, so the lines form a chain: editing, dropping or reordering any of
+// them breaks every later sum.
+type logEntry struct {
+	Seq     int          `json:"seq"`
+	Time    time.Time    `json:"time"`
+	Type    string       `json:"type"`
+	IP      string       `json:"ip,omitempty"`
+	Host    *HostResult  `json:"host,omitempty"`
+	Port    *PortResult  `json:"port,omitempty"`
+	Name    string       `json:"name,omitempty"`
+	Error   string       `json:"error,omitempty"`
+	Summary *logManifest `json:"manifest,omitempty"`
+	Sum     string       `json:"sha256,omitempty"`
+}
+
+// logManifest closes the events of one scan. Later scans may append to
+// the same log, continuing the chain after it.
+type logManifest struct {
+	Events   int       `json:"events"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+}
+
+// eventLog appends scan events to w as they happen, one checksummed JSON
+// object per line, for consumption with tail -f. Only the aggregators
+// write to it, one at a time.
+type eventLog struct {
+	w       io.Writer
+	seq     int
+	prev    string
+	first   int // seq of this scan's first event
+	started time.Time
+	err     error // first write error; later writes are skipped
+}
+
+// newEventLog returns a log writing to w that continues the chain of
+// the log tail describes, the zero value for a new log.
+func newEventLog(w io.Writer, tail logTail) *eventLog {
+	return &eventLog{w: w, seq: tail.lines, prev: tail.sum, first: tail.lines + 1, started: time.Now()}
+}
+
+// openEventLog opens the log file name for appending. An existing log is
+// verified first, since a scan cannot continue a broken chain, and the
+// new events follow its last manifest.
+func openEventLog(name string) (*eventLog, io.Closer, error) {
+	var tail logTail
+	if r, err := openFile(name); err == nil {
+		tail, err = readEventLog(r)
+		r.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot append to %s: %w", name, err)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, err
+	}
+	f, err := appendFile(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return newEventLog(f, tail), f, nil
+}
+
+// record appends ev to the log.
+func (l *eventLog) record(ev scanEvent) {
+	var e logEntry
+	switch ev := ev.(type) {
+	case hostUpEvent:
+		e.Type, e.IP, e.Host = "host_up", ev.Host.IP, &ev.Host
+	case hostDownEvent:
+		e.Type, e.IP = "host_down", ev.IP
+	case portResultEvent:
+		e.Type, e.IP, e.Port = "port", ev.IP, &ev.Result
+	case netbiosEvent:
+		e.Type, e.IP, e.Name = "netbios", ev.IP, ev.Name
+	case ptrEvent:
+		e.Type, e.IP, e.Name = "ptr", ev.IP, ev.Name
+	case errorEvent:
+		e.Type, e.IP, e.Error = "error", ev.IP, ev.Err.Error()
+		if ev.Port != 0 {
+			e.Port = &PortResult{Port: ev.Port}
+		}
+	default:
+		return
+	}
+	l.write(e)
+}
+
+// finish appends the manifest. It returns the first error writing the
+// log hit.
+func (l *eventLog) finish() error {
+	l.write(logEntry{Type: "manifest", Summary: &logManifest{
+		Events: l.seq - l.first + 1, Started: l.started, Finished: time.Now(),
+	}})
+	return l.err
+}
+
+func (l *eventLog) write(e logEntry) {
+	if l.err != nil {
+		return
+	}
+	l.seq++
+	e.Seq, e.Time = l.seq, time.Now()
+	body, err := json.Marshal(e)
+	if err != nil {
+		l.err = err
+		return
+	}
+	l.prev = chainSum(l.prev, body)
+	// The sum goes last, so a reader can strip it to get body back.
+	line := fmt.Sprintf("%s,%q:%q}\n", body[:len(body)-1], "sha256", l.prev)
+	_, l.err = io.WriteString(l.w, line)
+}
+
+// chainSum is the sum of a line with the given body, following the line
+// with sum prev.
+func chainSum(prev string, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, prev)
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// logTail describes the end of a verified log.
+type logTail struct {
+	lines  int    // lines in the log, events and manifests
+	sum    string // sum of the last line
+	events int    // events, not counting the manifests
+	scans  int    // manifests, one per scan that wrote to the log
+}
+
+// readEventLog checks the chain of sums in a log written by eventLog, and
+// that every scan's events end with a manifest counting them. An empty
+// log is valid and has the zero tail.
+func readEventLog(r io.Reader) (logTail, error) {
+	var t logTail
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	since := 0 // events since the last manifest
+	for sc.Scan() {
+		t.lines++
+		var e logEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return logTail{}, fmt.Errorf("line %d: %w", t.lines, err)
+		}
+		suffix := fmt.Sprintf(",%q:%q}", "sha256", e.Sum)
+		body, ok := bytes.CutSuffix(sc.Bytes(), []byte(suffix))
+		if !ok || e.Seq != t.lines || chainSum(t.sum, append(body, '}')) != e.Sum {
+			return logTail{}, fmt.Errorf("line %d: checksum mismatch, the log was modified", t.lines)
+		}
+		t.sum = e.Sum
+		if e.Summary == nil {
+			since++
+			continue
+		}
+		if e.Summary.Events != since {
+			return logTail{}, fmt.Errorf("line %d: manifest counts %d events, the scan logged %d", t.lines, e.Summary.Events, since)
+		}
+		t.events += since
+		t.scans++
+		since = 0
+	}
+	if err := sc.Err(); err != nil {
+		return logTail{}, err
+	}
+	if since > 0 {
+		return logTail{}, fmt.Errorf("no manifest after line %d; the log is truncated or the scan did not finish", t.lines)
+	}
+	return t, nil
+}
+
+// verifyEventLog checks a log like readEventLog and also rejects an empty
+// one. It returns the number of events and of scans.
+func verifyEventLog(r io.Reader) (int, int, error) {
+	t, err := readEventLog(r)
+	if err == nil && t.scans == 0 {
+		err = fmt.Errorf("the log is empty")
+	}
+	return t.events, t.scans, err
+}
+
+// runVerifyLog implements the verify-log subcommand.
+func runVerifyLog(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: verify-log file")
+	}
+	f, err := openFile(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	n, scans, err := verifyEventLog(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", args[0], err)
+	}
+	fmt.Fprintf(console, "%s: %d events from %d scans, checksums intact\n", args[0], n, scans)
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// writeScan appends the events of one small scan to buf, continuing the
+// chain of what buf already holds.
+func writeScan(t *testing.T, buf *bytes.Buffer) {
+	t.Helper()
+	tail, err := readEventLog(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("readEventLog before appending: %v", err)
+	}
+	l := newEventLog(buf, tail)
+	l.record(hostUpEvent{Host: HostResult{IP: "10.0.0.1", Method: "icmp"}})
+	l.record(portResultEvent{IP: "10.0.0.1", Result: PortResult{Port: 22, State: "open"}})
+	l.record(portResultEvent{IP: "10.0.0.1", Result: PortResult{Port: 80, State: "open"}})
+	l.record(hostDownEvent{IP: "10.0.0.2"})
+	l.record(errorEvent{IP: "10.0.0.3", Err: errors.New("probe panicked")})
+	if err := l.finish(); err != nil {
+		t.Fatalf("finish: %v", err)
+	}
+}
+
+func TestEventLogVerifies(t *testing.T) {
+	var buf bytes.Buffer
+	writeScan(t, &buf)
+	events, scans, err := verifyEventLog(&buf)
+	if err != nil || events != 5 || scans != 1 {
+		t.Errorf("verifyEventLog = %d events, %d scans, %v; want 5, 1, nil", events, scans, err)
+	}
+}
+
+func TestEventLogAppendVerifies(t *testing.T) {
+	var buf bytes.Buffer
+	writeScan(t, &buf)
+	writeScan(t, &buf)
+	events, scans, err := verifyEventLog(&buf)
+	if err != nil || events != 10 || scans != 2 {
+		t.Errorf("verifyEventLog = %d events, %d scans, %v; want 10, 2, nil", events, scans, err)
+	}
+}
+
+func TestEventLogTampering(t *testing.T) {
+	var buf bytes.Buffer
+	writeScan(t, &buf)
+	writeScan(t, &buf)
+	lines := strings.SplitAfter(strings.TrimSuffix(buf.String(), "\n"), "\n")
+
+	tests := []struct {
+		name   string
+		tamper func(lines []string) []string
+	}{
+		{"edit", func(l []string) []string {
+			l[1] = strings.Replace(l[1], `"port":22`, `"port":23`, 1)
+			return l
+		}},
+		{"edit the manifest", func(l []string) []string {
+			l[5] = strings.Replace(l[5], `"events":5`, `"events":4`, 1)
+			return l
+		}},
+		{"delete", func(l []string) []string { return append(l[:2], l[3:]...) }},
+		{"delete the first line", func(l []string) []string { return l[1:] }},
+		{"delete a whole scan", func(l []string) []string { return l[6:] }},
+		{"reorder", func(l []string) []string {
+			l[1], l[2] = l[2], l[1]
+			return l
+		}},
+		{"truncate", func(l []string) []string { return l[:len(l)-1] }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tampered := strings.Join(tt.tamper(append([]string(nil), lines...)), "")
+			if _, _, err := verifyEventLog(strings.NewReader(tampered)); err == nil {
+				t.Errorf("verifyEventLog accepted the log after %s", tt.name)
+			}
+		})
+	}
+}
+
+func TestEventLogEmpty(t *testing.T) {
+	if _, _, err := verifyEventLog(strings.NewReader("")); err == nil {
+		t.Error("verifyEventLog accepted an empty log")
+	}
+}
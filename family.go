@@ -0,0 +1,124 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// FamilyDiff is a port open on the addresses of a hostname in one address
+// family but not in the other, which usually means the IPv4 and IPv6
+// firewall rules have drifted apart.
+type FamilyDiff struct {
+	Name   string   `json:"name" xml:"name,attr"`
+	Port   int      `json:"port" xml:"port,attr"`
+	OpenOn string   `json:"open_on" xml:"open-on,attr"` // "IPv4" or "IPv6"
+	Open   []string `json:"open" xml:"open"`            // addresses of that family with the port open
+	Other  []string `json:"other" xml:"other"`          // addresses of the other family, with the port's state there
+}
+
+// familyPhase compares the open ports of the IPv4 and IPv6 addresses a
+// hostname target resolved to, see newDNSProvider. Names with a
+// quarantined address are left out; its ports say nothing about the
+// firewall.
+type familyPhase struct{}
+
+func (familyPhase) Name() string { return "address family comparison" }
+
+func (familyPhase) Run(s *Scan) error {
+	type family struct{ v4, v6 []string }
+	names := make(map[string]*family)
+	var order []string
+	for _, t := range s.Expanded {
+		if t.Name == "" {
+			continue
+		}
+		f := names[t.Name]
+		if f == nil {
+			f = &family{}
+			names[t.Name] = f
+			order = append(order, t.Name)
+		}
+		if addr, err := netip.ParseAddr(t.IP); err == nil && addr.Is4() {
+			f.v4 = append(f.v4, t.IP)
+		} else {
+			f.v6 = append(f.v6, t.IP)
+		}
+	}
+	byIP := make(map[string]*HostResult)
+	for _, h := range s.Hosts {
+		byIP[h.IP] = h
+	}
+
+	s.Families = nil
+	for _, name := range order {
+		f := names[name]
+		quarantined := slices.ContainsFunc(slices.Concat(f.v4, f.v6), func(ip string) bool {
+			return byIP[ip] != nil && byIP[ip].quarantined()
+		})
+		if len(f.v4) == 0 || len(f.v6) == 0 || quarantined {
+			continue
+		}
+		s.Families = append(s.Families, familyDiffs(name, "IPv4", f.v4, f.v6, byIP)...)
+		s.Families = append(s.Families, familyDiffs(name, "IPv6", f.v6, f.v4, byIP)...)
+	}
+	slices.SortFunc(s.Families, func(a, b FamilyDiff) int {
+		return cmp.Or(strings.Compare(a.Name, b.Name), cmp.Compare(a.Port, b.Port), strings.Compare(a.OpenOn, b.OpenOn))
+	})
+	return nil
+}
+
+// familyDiffs returns the ports open on some of the addresses in open,
+// of family fam, and on none of those in other.
+func familyDiffs(name, fam string, open, other []string, byIP map[string]*HostResult) []FamilyDiff {
+	hostsOf := func(ips []string) []*HostResult {
+		var hosts []*HostResult
+		for _, ip := range ips {
+			if h := byIP[ip]; h != nil {
+				hosts = append(hosts, h)
+			}
+		}
+		return hosts
+	}
+	here, there := openPortHosts(hostsOf(open)), openPortHosts(hostsOf(other))
+	var diffs []FamilyDiff
+	for port, ips := range here {
+		if _, ok := there[port]; ok {
+			continue
+		}
+		d := FamilyDiff{Name: name, Port: port, OpenOn: fam, Open: ips}
+		for _, ip := range other {
+			d.Other = append(d.Other, ip+" "+portState(byIP[ip], port))
+		}
+		diffs = append(diffs, d)
+	}
+	return diffs
+}
+
+// portState describes what the scan saw of port on h, which is nil if the
+// host did not answer discovery.
+func portState(h *HostResult, port int) string {
+	if h == nil {
+		return "down"
+	}
+	if i := slices.IndexFunc(h.Ports, func(p PortResult) bool { return p.Port == port }); i >= 0 {
+		return h.Ports[i].State
+	}
+	// Closed and, outside ACK scans, filtered ports are not kept.
+	return "closed or filtered"
+}
+
+// printFamilyDiffs writes the address family section of the summary.
+func printFamilyDiffs(w io.Writer, diffs []FamilyDiff) {
+	if len(diffs) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\nPorts open in one address family only (%d):\n", len(diffs))
+	for _, d := range diffs {
+		fmt.Fprintf(w, "  %s port %d is open over %s on %s, but not over the other family: %s\n",
+			d.Name, d.Port, d.OpenOn, strings.Join(d.Open, ", "), strings.Join(d.Other, ", "))
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestFamilyPhase(t *testing.T) {
+	s := &Scan{
+		Expanded: []Target{
+			{IP: "10.0.0.1", Name: "www.example.com"},
+			{IP: "2001:db8::1", Name: "www.example.com"},
+			{IP: "10.0.0.2", Name: "v4only.example.com"},
+			{IP: "10.0.0.3", Name: "down6.example.com"},
+			{IP: "2001:db8::3", Name: "down6.example.com"},
+			{IP: "10.0.0.4", Name: "trap.example.com"},
+			{IP: "2001:db8::4", Name: "trap.example.com"},
+			{IP: "10.0.0.5"},
+		},
+		Hosts: []*HostResult{
+			{IP: "10.0.0.1", Ports: []PortResult{{Port: 80, State: "open"}, {Port: 443, State: "open"}}},
+			{IP: "2001:db8::1", Ports: []PortResult{{Port: 22, State: "open"}, {Port: 443, State: "open"}}},
+			{IP: "10.0.0.2", Ports: []PortResult{{Port: 80, State: "open"}}},
+			{IP: "10.0.0.3", Ports: []PortResult{{Port: 25, State: "open"}}},
+			{IP: "10.0.0.4", Ports: []PortResult{{Port: 25, State: "open"}}},
+			{IP: "2001:db8::4", Honeypot: []string{"all 20 scanned ports are open"}, Ports: []PortResult{{Port: 21, State: "open"}}},
+			{IP: "10.0.0.5", Ports: []PortResult{{Port: 8080, State: "open"}}},
+		},
+	}
+	if err := (familyPhase{}).Run(s); err != nil {
+		t.Fatal(err)
+	}
+	want := []FamilyDiff{
+		{Name: "down6.example.com", Port: 25, OpenOn: "IPv4", Open: []string{"10.0.0.3"}, Other: []string{"2001:db8::3 down"}},
+		{Name: "www.example.com", Port: 22, OpenOn: "IPv6", Open: []string{"2001:db8::1"}, Other: []string{"10.0.0.1 closed or filtered"}},
+		{Name: "www.example.com", Port: 80, OpenOn: "IPv4", Open: []string{"10.0.0.1"}, Other: []string{"2001:db8::1 closed or filtered"}},
+	}
+	if len(s.Families) != len(want) {
+		t.Fatalf("got %+v, want %d differences", s.Families, len(want))
+	}
+	for i, w := range want {
+		got := s.Families[i]
+		if got.Name != w.Name || got.Port != w.Port || got.OpenOn != w.OpenOn ||
+			!slices.Equal(got.Open, w.Open) || !slices.Equal(got.Other, w.Other) {
+			t.Errorf("difference %d = %+v, want %+v", i, got, w)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package main
+
+import (
+	"net"
+	"syscall"
+
+	"golang.org/x/net/route"
+)
+
+// defaultGateway reads the IPv4 default route from the kernel routing
+// table via the routing sysctl.
+func defaultGateway() string {
+	rib, err := route.FetchRIB(syscall.AF_INET, route.RIBTypeRoute, 0)
+	if err != nil {
+		return ""
+	}
+	msgs, err := route.ParseRIB(route.RIBTypeRoute, rib)
+	if err != nil {
+		return ""
+	}
+
+	for _, msg := range msgs {
+		rm, ok := msg.(*route.RouteMessage)
+		if !ok || rm.Flags&syscall.RTF_GATEWAY == 0 || len(rm.Addrs) <= syscall.RTAX_GATEWAY {
+			continue
+		}
+		dst, ok := rm.Addrs[syscall.RTAX_DST].(*route.Inet4Addr)
+		if !ok || dst.IP != [4]byte{} {
+			continue
+		}
+		if gw, ok := rm.Addrs[syscall.RTAX_GATEWAY].(*route.Inet4Addr); ok {
+			return net.IP(gw.IP[:]).String()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,9 @@
+//go:build !(darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package main
+
+// defaultGateway is not implemented on this platform; getGatewayIP falls
+// back to guessing from the interface addresses.
+func defaultGateway() string {
+	return ""
+}
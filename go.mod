@@ -5,6 +5,8 @@ go 1.23.0
 toolchain go1.24.1
 
 require (
-	golang.org/x/net v0.37.0 // indirect
-	golang.org/x/sys v0.31.0 // indirect
+	github.com/klauspost/compress v1.18.0
+	golang.org/x/net v0.37.0
 )
+
+require golang.org/x/sys v0.31.0 // indirect
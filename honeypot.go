@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+)
+
+const (
+	// Below this many ports there is not enough evidence to call a host a
+	// honeypot; a real host can easily answer on every port of a short list.
+	honeypotMinPorts = 20
+	// Open ports whose connect latencies all fall within this fraction of
+	// the fastest one are considered suspiciously uniform; real stacks jitter.
+	honeypotLatencySpread = 0.05
+)
+
+// honeypotSignals returns the reasons a host's open ports look like a
+// honeypot or tarpit rather than real services. scanned is the number of
+// ports probed on the host.
+func honeypotSignals(open []PortResult, scanned int) []string {
+	var signals []string
+	if scanned >= honeypotMinPorts && len(open) == scanned {
+		signals = append(signals, fmt.Sprintf("all %d scanned ports are open", scanned))
+	}
+
+	if len(open) >= honeypotMinPorts {
+		fastest, slowest := open[0].Latency, open[0].Latency
+		for _, r := range open[1:] {
+			fastest = min(fastest, r.Latency)
+			slowest = max(slowest, r.Latency)
+		}
+		if float64(slowest-fastest) < float64(fastest)*honeypotLatencySpread {
+			signals = append(signals, fmt.Sprintf("%d open ports answered within %v of each other", len(open), slowest-fastest))
+		}
+	}
+	return signals
+}
+
+// markHoneypot records the honeypot signals of h's open ports on h, once
+// its port scan is done, so that the summary, the writers and the later
+// phases all leave the same hosts out.
+func markHoneypot(h *HostResult, scanned int) {
+	var open []PortResult
+	for _, p := range h.Ports {
+		if p.State == "open" {
+			open = append(open, p)
+		}
+	}
+	h.Honeypot = honeypotSignals(open, scanned)
+}
@@ -1,34 +1,81 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
 	"net"
+	"net/netip"
 	"os"
-	"sync"
-	"time"
-	"strings"
 	"strconv"
+	"syscall"
+	"time"
+
 	"golang.org/x/net/icmp"
 	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// icmpFamily describes how to ping hosts of one address family.
+type icmpFamily struct {
+	raw, dgram  string // icmp.ListenPacket networks, privileged and not
+	listen      string // wildcard address to listen on
+	proto       int    // IANA protocol number, for icmp.ParseMessage
+	echo, reply icmp.Type
+
+	// network is the one pingHost uses. It starts as the raw socket and is
+	// downgraded by selectNetwork when that is not permitted.
+	network string
+}
+
+var (
+	icmp4 = &icmpFamily{
+		raw: "ip4:icmp", dgram: "udp4", listen: "0.0.0.0", proto: 1,
+		echo: ipv4.ICMPTypeEcho, reply: ipv4.ICMPTypeEchoReply, network: "ip4:icmp",
+	}
+	icmp6 = &icmpFamily{
+		raw: "ip6:ipv6-icmp", dgram: "udp6", listen: "::", proto: 58,
+		echo: ipv6.ICMPTypeEchoRequest, reply: ipv6.ICMPTypeEchoReply, network: "ip6:ipv6-icmp",
+	}
 )
 
-type ScanResult struct {
-	IP   string
-	Port int
-	Open bool
+// selectNetwork picks the most capable ICMP socket type available to the
+// current user: raw sockets when privileged, otherwise datagram ICMP
+// sockets as supported by macOS and Linux (net.ipv4.ping_group_range).
+func (f *icmpFamily) selectNetwork() error {
+	var err error
+	for _, network := range []string{f.raw, f.dgram} {
+		var c *icmp.PacketConn
+		if c, err = icmp.ListenPacket(network, f.listen); err == nil {
+			c.Close()
+			f.network = network
+			return nil
+		}
+	}
+	return err
 }
 
-func pingHost(ip string, timeout time.Duration) bool {
-	c, err := icmp.ListenPacket("ip4:icmp", "0.0.0.0")
+// pingHost sends an ICMP echo request to ip and reports whether a reply
+// arrived within timeout, and how long it took. An error means the probe
+// could not be sent.
+func pingHost(ip string, timeout time.Duration) (bool, time.Duration, error) {
+	dst := net.ParseIP(ip)
+	family := icmp4
+	if dst.To4() == nil {
+		family = icmp6
+	}
+
+	c, err := icmp.ListenPacket(family.network, family.listen)
 	if err != nil {
-		fmt.Printf("Error creating ICMP listener: %v\n", err)
-		return false
+		return false, 0, fmt.Errorf("creating ICMP listener: %w", err)
 	}
 	defer c.Close()
 
 	msg := icmp.Message{
-		Type: ipv4.ICMPTypeEcho,
+		Type: family.echo,
 		Code: 0,
 		Body: &icmp.Echo{
 			ID:   os.Getpid() & 0xffff,
@@ -39,62 +86,118 @@ func pingHost(ip string, timeout time.Duration) bool {
 
 	msgBytes, err := msg.Marshal(nil)
 	if err != nil {
-		return false
+		return false, 0, err
 	}
 
-	dest := net.ParseIP(ip)
-	if _, err := c.WriteTo(msgBytes, &net.IPAddr{IP: dest}); err != nil {
-		return false
+	var dest net.Addr = &net.IPAddr{IP: dst}
+	if family.network == family.dgram {
+		dest = &net.UDPAddr{IP: dst}
+	}
+	start := time.Now()
+	if _, err := c.WriteTo(msgBytes, dest); err != nil {
+		return false, 0, fmt.Errorf("sending echo request: %w", err)
 	}
+	probeBytes.sent.Add(int64(len(msgBytes)))
 
+	// A raw socket sees all ICMP traffic, including neighbor discovery on
+	// IPv6, so wait for an echo reply from the target itself.
 	c.SetReadDeadline(time.Now().Add(timeout))
 	reply := make([]byte, 1500)
-	_, _, err = c.ReadFrom(reply)
-	return err == nil
+	for {
+		n, peer, err := c.ReadFrom(reply)
+		if err != nil {
+			return false, 0, nil
+		}
+		probeBytes.received.Add(int64(n))
+		if !peerIP(peer).Equal(dst) {
+			continue
+		}
+		if m, err := icmp.ParseMessage(family.proto, reply[:n]); err == nil && m.Type == family.reply {
+			return true, time.Since(start), nil
+		}
+	}
 }
 
-func scanPort(ip string, port int, timeout time.Duration) ScanResult {
-	target := fmt.Sprintf("%s:%d", ip, port)
+func peerIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.IPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	}
+	return nil
+}
+
+// scanPort attempts a TCP connection to ip:port. An error is returned only
+// when the attempt failed locally, see isLocalError.
+func scanPort(ip string, port int, timeout time.Duration) (PortResult, error) {
+	target := net.JoinHostPort(ip, strconv.Itoa(port))
+	start := time.Now()
 	conn, err := net.DialTimeout("tcp", target, timeout)
 
-	result := ScanResult{IP: ip, Port: port}
+	result := PortResult{Port: port, Latency: time.Since(start)}
 	if err != nil {
-		result.Open = false
-		return result
+		if isLocalError(err) {
+			return result, err
+		}
+		// A refusal means the host answered with a RST; anything else,
+		// usually a timeout, means something dropped the SYN.
+		result.State = "filtered"
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			result.State = "closed"
+		}
+		return result, nil
 	}
 	conn.Close()
-	result.Open = true
-	return result
+	result.State = "open"
+	result.Service = serviceName(port)
+	return result, nil
 }
 
-func generateIPs(startIP, endIP string) ([]string, error) {
-	start := net.ParseIP(startIP).To4()
-	end := net.ParseIP(endIP).To4()
-	if start == nil || end == nil {
-		return nil, fmt.Errorf("invalid IP address")
-	}
-
-	var ips []string
-	for ip := start; ip != nil && bytes2int(ip) <= bytes2int(end); inc(ip) {
-		ips = append(ips, ip.String())
-	}
-	return ips, nil
+// isLocalError reports whether a dial failure was caused by the scanning
+// machine, such as running out of file descriptors, rather than by the
+// target refusing or ignoring the connection.
+func isLocalError(err error) bool {
+	return errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE) ||
+		errors.Is(err, syscall.ENOBUFS) || errors.Is(err, syscall.EADDRNOTAVAIL)
 }
 
-func bytes2int(b net.IP) uint32 {
-	return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+// parseRange validates an address range and returns its bounds. Both ends
+// must be in the same address family.
+func parseRange(startIP, endIP string) (netip.Addr, netip.Addr, error) {
+	start, err := netip.ParseAddr(startIP)
+	if err != nil {
+		return netip.Addr{}, netip.Addr{}, fmt.Errorf("invalid start IP address %q", startIP)
+	}
+	end, err := netip.ParseAddr(endIP)
+	if err != nil {
+		return netip.Addr{}, netip.Addr{}, fmt.Errorf("invalid end IP address %q", endIP)
+	}
+	start, end = start.Unmap().WithZone(""), end.Unmap().WithZone("")
+	if start.Is4() != end.Is4() {
+		return netip.Addr{}, netip.Addr{}, fmt.Errorf("start address %s and end address %s are in different address families", start, end)
+	}
+	if start.Compare(end) > 0 {
+		return netip.Addr{}, netip.Addr{}, fmt.Errorf("start address %s is after end address %s", start, end)
+	}
+	return start, end, nil
 }
 
-func inc(ip net.IP) {
-	for j := len(ip) - 1; j >= 0; j-- {
-		ip[j]++
-		if ip[j] > 0 {
-			break
-		}
+// lastAddr returns the highest address of a prefix.
+func lastAddr(p netip.Prefix) netip.Addr {
+	b := p.Masked().Addr().AsSlice()
+	for i := p.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 0x80 >> (i % 8)
 	}
+	last, _ := netip.AddrFromSlice(b)
+	return last
 }
 
 func getGatewayIP() string {
+	if gw := defaultGateway(); gw != "" {
+		return gw
+	}
+
 	interfaces, err := net.Interfaces()
 	if err != nil {
 		return ""
@@ -119,31 +222,163 @@ func getGatewayIP() string {
 }
 
 func checkInternetConnectivity() bool {
-	return pingHost("8.8.8.8", 2*time.Second)
+	up, _, _ := pingHost("8.8.8.8", 2*time.Second)
+	return up
 }
 
 func main() {
-	mode := flag.String("mode", "range", "Scan mode: range, specific, gateway, internet")
-	startIP := flag.String("start", "192.168.1.1", "Start IP address for range scan")
-	endIP := flag.String("end", "192.168.1.255", "End IP address for range scan")
-	specificIP := flag.String("ip", "", "Specific IP address to scan")
-	portRange := flag.String("ports", "1-1024", "Port range to scan (e.g., 80 or 1-1024)")
+	if len(os.Args) > 1 && os.Args[1] == "testbed" {
+		if err := runTestbed(os.Args[2:]); err != nil {
+			fmt.Fprintf(console, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "verify-log" {
+		if err := runVerifyLog(os.Args[2:]); err != nil {
+			fmt.Fprintf(console, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "merge" {
+		if err := runMerge(os.Args[2:]); err != nil {
+			fmt.Fprintf(console, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	mode := flag.String("mode", "range", "Scan mode: range, specific, gateway, internet, compare, reach")
+	startIP := flag.String("start", "192.168.1.1", "Start IP address or hostname for range scan")
+	endIP := flag.String("end", "192.168.1.255", "End IP address or hostname for range scan")
+	specificIP := flag.String("ip", "", "Specific IP address or hostname to scan")
+	target := flag.String("target", "", "Address, hostname or CIDR block to scan in range mode (e.g. 192.168.1.0/24), instead of -start/-end; - reads a target list from stdin")
+	targetList := flag.String("iL", "", "Read targets (addresses, hostnames, ranges, CIDR blocks) from this file, one per line; - reads stdin")
+	exclude := flag.String("exclude", "", "Comma-separated addresses and CIDR blocks to skip")
+	excludeFile := flag.String("exclude-file", "", "Skip the addresses and CIDR blocks listed in this file, one per line")
+	groupA := flag.String("a", "", "Comma-separated targets of the first group in compare mode")
+	groupB := flag.String("b", "", "Comma-separated targets of the second group in compare mode")
+	interval := flag.Duration("interval", time.Second, "Time between probes in reach mode")
+	count := flag.Int("count", 0, "Number of probes in reach mode, 0 runs until interrupted")
+	source := flag.String("source", "", "Local address to probe from in reach mode")
+	cloud := flag.String("cloud", "", "Scan a cloud provider's published ranges in range mode, as `provider[:region[:service]]` (aws, gcp, azure)")
+	cloudRanges := flag.String("cloud-ranges", "", "Read -cloud ranges from this JSON file instead of downloading them")
+	portRange := flag.String("ports", "1-1024", "Ports to scan, as a comma-separated list of ports, ranges and service names (e.g. ssh,80,https,8000-8100)")
+	top := flag.Int("top-ports", 0, fmt.Sprintf("Scan the `N` most commonly open TCP ports instead of -ports; at most %[1]d, as the embedded frequency table ranks only the top %[1]d", len(services)))
 	timeout := flag.Duration("timeout", 500*time.Millisecond, "Timeout for each scan")
+	adaptive := flag.Bool("adaptive-timeout", false, "Time out port probes after 4x each host's discovery RTT, at least 100ms and at most -timeout")
+	relayTest := flag.Bool("smtp-relay-test", false, "Let the SMTP audit check whether open SMTP ports relay mail between outside domains (stops before DATA; nothing is sent)")
+	maxRate := flag.Float64("max-rate", 0, "Send at most this many probes per second across the whole scan; 0 for no limit")
+	minRate := flag.Float64("min-rate", 0, "Keep enough probes in flight to send at least this many per second")
+	ad := flag.Bool("ad", false, "Summarise the Active Directory domain of any domain controller found, from its rootDSE and DNS SRV records; needs no credentials")
+	hostPar := flag.Int("host-parallelism", discoveryWorkers, "Discover or scan at most this many hosts at once")
+	portPar := flag.Int("port-parallelism", 0, "Probe at most this many ports of each host at once; 0 for no limit beyond -workers")
+	phaseTimeout := flag.Duration("phase-timeout", 0, "Stop each of the vhost, SMTP, cleartext and AD phases after this long, skipping the ports it has not reached; 0 for no limit")
+	workers := flag.Int("workers", defaultWorkers, "Probe at most this many ports at once; each holds a socket")
+	retries := flag.Int("retries", 0, "Retry discovery and port probes that get no answer this many times")
+	maxProbes := flag.Uint64("max-probes", 1000000, "Refuse scans that would send more probes than this")
+	confirm := flag.Bool("confirm", false, "Run the scan even if it exceeds -max-probes")
+	output := flag.String("o", "", "Write results as JSON lines to this file; - for stdout (progress then goes to stderr)")
+	bundle := flag.String("oA", "", "Write results to `basename`.json, .xml, .html and .txt")
+	eventLogName := flag.String("log", "", "Append every scan event to this file as checksummed JSON lines, ending with a manifest; an existing log is verified and its chain continued")
+	compress := flag.Bool("compress", false, "Compress the -oA files with zstd, adding a .zst suffix; -o compresses names ending in .zst")
+	discovery := flag.String("discovery", "icmp,syn:80,443", "Host discovery probes, tried in order: icmp, syn[:port,...] and ack[:port,...]")
+	mdns := flag.Bool("mdns", false, "Also discover hosts and their names with mDNS queries on the local network")
+	ssdp := flag.Bool("ssdp", false, "Also discover UPnP devices with SSDP searches and report their names and models")
+	rdns := flag.Bool("rdns", true, "Look up the hostnames of live hosts; -rdns=false skips it when the resolver is slow")
+	vhostFile := flag.String("vhosts", "", "Try the hostnames in this file, one per line, as TLS server names and HTTP Host headers on open ports to find virtual hosts")
+	netbios := flag.Bool("netbios", true, "Ask live hosts for their NetBIOS machine and workgroup names (UDP 137)")
+	skipDiscovery := flag.Bool("Pn", false, "Skip host discovery and port scan every target")
+	scanType := flag.String("scan", "connect", "Port scan type: connect, or syn, fin, null, xmas or ack over raw sockets (needs root on Linux; connect elsewhere)")
+	randomize := flag.Bool("randomize", false, "Probe hosts and ports in random order")
+	seed := flag.Uint64("seed", 0, "Seed the -randomize order, to repeat the probe order of an earlier scan; implies -randomize")
+	dumpStack := flag.Bool("debug-stack", false, "Print stack traces for probes that panic")
 	flag.Parse()
 
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if err := checkUsage(*mode, *scanType, *specificIP, *startIP, *endIP, set); err != nil {
+		fmt.Fprintf(console, "Error: %v\n", err)
+		if ue, ok := err.(*usageError); ok && ue.hint != "" {
+			fmt.Fprintf(console, "Did you mean: %s\n", ue.hint)
+		}
+		os.Exit(2)
+	}
+
+	// A scan whose results could not be written fails. Deferred first, so
+	// the output and the event log are closed before the process exits.
+	exitCode := 0
+	defer func() {
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}()
+
+	var out io.WriteCloser
+	if *output != "" {
+		var err error
+		if out, err = openOutput(*output); err != nil {
+			fmt.Fprintf(console, "Error opening output: %v\n", err)
+			return
+		}
+		defer func() {
+			if err := out.Close(); err != nil {
+				fmt.Fprintf(console, "Error writing output: %v\n", err)
+				exitCode = 1
+			}
+		}()
+	}
+
+	var events *eventLog
+	if *eventLogName != "" {
+		l, f, err := openEventLog(*eventLogName)
+		if err != nil {
+			fmt.Fprintf(console, "Error opening event log: %v\n", err)
+			return
+		}
+		events = l
+		defer func() {
+			err := events.finish()
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				fmt.Fprintf(console, "Error writing event log: %v\n", err)
+				exitCode = 1
+			}
+		}()
+	}
+
+	icmpErr := icmp4.selectNetwork()
+	if icmpErr != nil {
+		fmt.Fprintf(console, "ICMP discovery unavailable (%v); discovering hosts with TCP ping instead\n", icmpErr)
+	} else if icmp4.network == icmp4.dgram {
+		fmt.Fprintln(console, "Not running as root: using unprivileged ICMP echo for discovery (raw ICMP disabled)")
+	}
+	// Hosts without IPv6 fail here; pinging a v6 target then reports the
+	// error for that target.
+	icmp6.selectNetwork()
+	if *scanType != "connect" {
+		if err := checkRawTCP(); err != nil {
+			fmt.Fprintf(console, "Raw sockets unavailable (%v); falling back to connect scan\n", err)
+			*scanType = "connect"
+		}
+	}
+
 	switch *mode {
 	case "internet":
 		if checkInternetConnectivity() {
-			fmt.Println("Internet is accessible (Google DNS 8.8.8.8 responds to ping)")
+			fmt.Fprintln(console, "Internet is accessible (Google DNS 8.8.8.8 responds to ping)")
 		} else {
-			fmt.Println("No internet connectivity detected")
+			fmt.Fprintln(console, "No internet connectivity detected")
 		}
 		return
 
 	case "gateway":
 		gatewayIP := getGatewayIP()
 		if gatewayIP == "" {
-			fmt.Println("Could not determine gateway IP")
+			fmt.Fprintln(console, "Could not determine gateway IP")
 			return
 		}
 		*startIP = gatewayIP
@@ -151,75 +386,220 @@ func main() {
 
 	case "specific":
 		if *specificIP == "" {
-			fmt.Println("Please provide a specific IP address using -ip flag")
+			fmt.Fprintln(console, "Please provide a specific IP address using -ip flag")
+			return
+		}
+
+	case "compare":
+		if *groupA == "" || *groupB == "" {
+			fmt.Fprintln(console, "Please provide both target groups using the -a and -b flags")
+			return
+		}
+
+	case "reach":
+		if *specificIP == "" {
+			fmt.Fprintln(console, "Please provide the host to probe using -ip flag")
 			return
 		}
-		*startIP = *specificIP
-		*endIP = *specificIP
 	}
 
-	var ips []string
+	var ports []int
 	var err error
-	ips, err = generateIPs(*startIP, *endIP)
+	switch {
+	case *top != 0 && set["ports"]:
+		fmt.Fprintln(console, "Use either -ports or -top-ports, not both")
+		return
+	case *top != 0:
+		ports, err = topPorts(*top)
+	default:
+		ports, err = parsePorts(*portRange)
+	}
 	if err != nil {
-		fmt.Printf("Error generating IP range: %v\n", err)
+		fmt.Fprintf(console, "Error parsing ports: %v\n", err)
 		return
 	}
 
-	ports := make([]int, 0)
-	portParts := strings.Split(*portRange, "-")
-	startPort, _ := strconv.Atoi(portParts[0])
-	endPort := startPort
-	if len(portParts) > 1 {
-		endPort, _ = strconv.Atoi(portParts[1])
+	if *mode == "reach" {
+		if len(ports) != 1 {
+			fmt.Fprintln(console, "Reach mode probes a single port; pass it with -ports")
+			return
+		}
+		check := &reachCheck{Host: *specificIP, Port: ports[0], Source: *source, Interval: *interval, Timeout: *timeout, Count: *count}
+		if err := check.Run(); err != nil {
+			fmt.Fprintf(console, "Error: %v\n", err)
+		}
+		return
 	}
 
-	for i := startPort; i <= endPort; i++ {
-		ports = append(ports, i)
+	if *maxRate > 0 && *minRate > *maxRate {
+		fmt.Fprintf(console, "Error: -min-rate %g is higher than -max-rate %g\n", *minRate, *maxRate)
+		return
+	}
+	probeRate.setRate(*maxRate)
+	if *workers < 1 || *hostPar < 1 || *portPar < 0 || *retries < 0 {
+		fmt.Fprintln(console, "Error: -workers and -host-parallelism must be at least 1, -port-parallelism and -retries at least 0")
+		return
+	}
+	// The scan raises both pools to what -min-rate needs, which would
+	// silently override a limit given on the command line.
+	need := int(math.Ceil(*minRate * timeout.Seconds()))
+	for _, limit := range []struct {
+		name  string
+		value int
+	}{{"host-parallelism", *hostPar}, {"workers", *workers}} {
+		if set[limit.name] && need > limit.value {
+			fmt.Fprintf(console, "Error: -min-rate %g with -timeout %v keeps %d probes in flight, more than -%s %d allows\n",
+				*minRate, *timeout, need, limit.name, limit.value)
+			return
+		}
 	}
 
-	var wg sync.WaitGroup
-	results := make(chan ScanResult, len(ips)*len(ports))
-	activeHosts := make(map[string]bool)
-	var hostMutex sync.Mutex
+	probes, err := parseDiscovery(*discovery)
+	if err != nil {
+		fmt.Fprintf(console, "Error parsing -discovery: %v\n", err)
+		return
+	}
+	if icmpErr != nil {
+		probes = withoutICMP(probes)
+	}
 
-	for _, ip := range ips {
-		if pingHost(ip, *timeout) {
-			fmt.Printf("Host %s is up, scanning ports...\n", ip)
-			hostMutex.Lock()
-			activeHosts[ip] = true
-			hostMutex.Unlock()
-			for _, port := range ports {
-				wg.Add(1)
-				go func(ip string, port int) {
-					defer wg.Done()
-					results <- scanPort(ip, port, *timeout)
-				}(ip, port)
-			}
-		} else {
-			fmt.Printf("Host %s is down, skipping...\n", ip)
+	var sources multiProvider
+	if *mode == "specific" {
+		p, err := parseHost(*specificIP)
+		if err != nil {
+			fmt.Fprintf(console, "Error resolving target: %v\n", err)
+			return
 		}
+		sources = append(sources, p)
+	}
+	if *mode == "range" && *target == "-" {
+		sources = append(sources, newListFileProvider(os.Stdin, "stdin"))
+	} else if *mode == "range" && *target != "" {
+		p, err := parseTarget(*target)
+		if err != nil {
+			fmt.Fprintf(console, "Error generating IP range: %v\n", err)
+			return
+		}
+		sources = append(sources, p)
+	}
+	if *mode == "range" && *targetList == "-" && *target != "-" {
+		sources = append(sources, newListFileProvider(os.Stdin, "stdin"))
+	} else if *mode == "range" && *targetList != "" && *targetList != "-" {
+		f, err := os.Open(*targetList)
+		if err != nil {
+			fmt.Fprintf(console, "Error opening target list: %v\n", err)
+			return
+		}
+		defer f.Close()
+		sources = append(sources, newListFileProvider(f, *targetList))
+	}
+	if *mode == "range" && *cloud != "" {
+		p, err := newCloudProvider(*cloud, *cloudRanges)
+		if err != nil {
+			fmt.Fprintf(console, "Error loading cloud ranges: %v\n", err)
+			return
+		}
+		sources = append(sources, p)
+	}
+	if len(sources) == 0 && *mode != "compare" {
+		start, err := resolveBound(*startIP)
+		if err != nil {
+			fmt.Fprintf(console, "Error resolving start of range: %v\n", err)
+			return
+		}
+		end, err := resolveBound(*endIP)
+		if err != nil {
+			fmt.Fprintf(console, "Error resolving end of range: %v\n", err)
+			return
+		}
+		p, err := newRangeProvider(start, end)
+		if err != nil {
+			fmt.Fprintf(console, "Error generating IP range: %v\n", err)
+			return
+		}
+		sources = append(sources, p)
 	}
 
-	go func() {
-		wg.Wait()
-		close(results)
-	}()
+	var exclusions exclusionList
+	if err := exclusions.add(*exclude); err != nil {
+		fmt.Fprintf(console, "Error parsing -exclude: %v\n", err)
+		return
+	}
+	if *excludeFile != "" {
+		f, err := os.Open(*excludeFile)
+		if err != nil {
+			fmt.Fprintf(console, "Error opening exclude file: %v\n", err)
+			return
+		}
+		err = exclusions.addFile(f, *excludeFile)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(console, "Error reading exclude file: %v\n", err)
+			return
+		}
+	}
 
-	openPorts := make(map[string][]int)
-	for result := range results {
-		if result.Open {
-			openPorts[result.IP] = append(openPorts[result.IP], result.Port)
+	var vhosts []string
+	if *vhostFile != "" {
+		f, err := os.Open(*vhostFile)
+		if err != nil {
+			fmt.Fprintf(console, "Error opening vhost list: %v\n", err)
+			return
+		}
+		vhosts, err = readNames(f)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(console, "Error reading vhost list: %v\n", err)
+			return
 		}
 	}
 
-	fmt.Printf("\nScan Summary:\n")
-	fmt.Printf("Total active hosts found: %d\n", len(activeHosts))
-	for ip := range activeHosts {
-		if ports, ok := openPorts[ip]; ok {
-			fmt.Printf("Host %s has %d open ports: %v\n", ip, len(ports), ports)
-		} else {
-			fmt.Printf("Host %s is up but has no open ports in the specified range\n", ip)
+	if !set["seed"] {
+		*seed = rand.Uint64()
+	}
+	scan := &Scan{
+		Targets:   &sources,
+		Exclude:   exclusions,
+		Ports:     ports,
+		Timeout:   *timeout,
+		MaxProbes: *maxProbes,
+		Confirm:   *confirm,
+		Bundle:    *bundle,
+		Compress:  *compress,
+		Log:       events,
+		DumpStack: *dumpStack,
+		Randomize: *randomize || set["seed"],
+		Seed:      *seed,
+		ScanType:  *scanType,
+		Discovery: probes,
+		NoPing:    *skipDiscovery,
+		MDNS:      *mdns,
+		SSDP:      *ssdp,
+		NetBIOS:   *netbios,
+		RDNS:      *rdns,
+		VHosts:    vhosts,
+		Retries:   *retries,
+		Adaptive:  *adaptive,
+		RelayTest: *relayTest,
+		MinRate:   *minRate,
+		Workers:   *workers,
+		AD:        *ad,
+		HostLimit: *hostPar,
+		PortLimit: *portPar,
+		Budget:    *phaseTimeout,
+	}
+	if out != nil {
+		scan.Output = out
+	}
+	if *mode == "compare" {
+		if err := runCompare(scan, *groupA, *groupB); err != nil {
+			fmt.Fprintf(console, "Error during %v\n", err)
+			exitCode = 1
 		}
+		return
 	}
-}
\ No newline at end of file
+	if err := scan.Run(defaultPhases()); err != nil {
+		fmt.Fprintf(console, "Error during %v\n", err)
+		exitCode = 1
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// mdnsServices are the DNS-SD service types queried, covering the usual
+// home and office devices: Apple devices, printers, Chromecasts, NAS boxes.
+var mdnsServices = []string{
+	"_services._dns-sd._udp.local.",
+	"_device-info._tcp.local.",
+	"_airplay._tcp.local.",
+	"_raop._tcp.local.",
+	"_companion-link._tcp.local.",
+	"_googlecast._tcp.local.",
+	"_ipp._tcp.local.",
+	"_printer._tcp.local.",
+	"_pdl-datastream._tcp.local.",
+	"_smb._tcp.local.",
+	"_afpovertcp._tcp.local.",
+	"_workstation._tcp.local.",
+	"_http._tcp.local.",
+}
+
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// mdnsHost is what a device announced about itself over mDNS.
+type mdnsHost struct {
+	Hostname string   // from its A record, e.g. "Office-Printer.local"
+	Names    []string // service instance names, e.g. "Living Room"
+}
+
+// mdnsSweep multicasts mDNS queries for the common service types and
+// collects the answers that arrive within wait, keyed by responder
+// address. The queries come from an ephemeral port, so responders answer
+// by unicast (RFC 6762 section 6.7) and no port 5353 listener is needed.
+func mdnsSweep(wait time.Duration) (map[string]*mdnsHost, error) {
+	probeRate.wait()
+	c, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{})
+	b.StartQuestions()
+	for _, service := range mdnsServices {
+		err := b.Question(dnsmessage.Question{
+			Name:  dnsmessage.MustNewName(service),
+			Type:  dnsmessage.TypePTR,
+			Class: dnsmessage.ClassINET,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	query, err := b.Finish()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := c.WriteTo(query, mdnsGroup); err != nil {
+		return nil, fmt.Errorf("sending mDNS query: %w", err)
+	}
+	probeBytes.sent.Add(int64(len(query)))
+
+	hosts := make(map[string]*mdnsHost)
+	c.SetReadDeadline(time.Now().Add(wait))
+	buf := make([]byte, 9000)
+	for {
+		n, peer, err := c.ReadFromUDP(buf)
+		if err != nil {
+			return hosts, nil
+		}
+		probeBytes.received.Add(int64(n))
+		var msg dnsmessage.Message
+		if msg.Unpack(buf[:n]) != nil || !msg.Response {
+			continue
+		}
+		ip := peer.IP.String()
+		h := hosts[ip]
+		if h == nil {
+			h = &mdnsHost{}
+			hosts[ip] = h
+		}
+		h.add(&msg, peer.IP)
+	}
+}
+
+// add records the names in one response from ip.
+func (h *mdnsHost) add(msg *dnsmessage.Message, ip net.IP) {
+	records := append(slices.Clone(msg.Answers), msg.Additionals...)
+	for _, r := range records {
+		switch body := r.Body.(type) {
+		case *dnsmessage.AResource:
+			if h.Hostname == "" && net.IP(body.A[:]).Equal(ip) {
+				h.Hostname = strings.TrimSuffix(r.Header.Name.String(), ".")
+			}
+		case *dnsmessage.PTRResource:
+			// Answers to the service type enumeration name types,
+			// not instances.
+			if r.Header.Name.String() == mdnsServices[0] {
+				continue
+			}
+			instance := body.PTR.String()
+			if i := strings.Index(instance, "._"); i > 0 {
+				instance = instance[:i]
+			}
+			if !slices.Contains(h.Names, instance) {
+				h.Names = append(h.Names, instance)
+			}
+		}
+	}
+}
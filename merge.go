@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// runMerge implements the merge subcommand: it combines the results of
+// several scans, for instance from different vantage points, into one JSON
+// report. Every host and port records the inputs that reported it.
+func runMerge(args []string) error {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	output := fs.String("o", "-", "Write the merged JSON report to this file, - for stdout")
+	matrix := fs.Bool("matrix", false, "Print which open ports each input, or vantage point, could reach")
+	// Allow the flag after the file names, as in "merge a.json b.json -o m.json".
+	var files []string
+	for {
+		fs.Parse(args)
+		if fs.NArg() == 0 {
+			break
+		}
+		files = append(files, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if len(files) < 2 {
+		return fmt.Errorf("merge needs at least two result files")
+	}
+
+	var merged resultMerge
+	for _, name := range files {
+		if err := merged.addFile(name); err != nil {
+			return err
+		}
+	}
+
+	out, err := openOutput(*output)
+	if err != nil {
+		return err
+	}
+	sortFindings(merged.findings)
+	slices.SortFunc(merged.domains, func(a, b ADDomain) int { return strings.Compare(a.Name, b.Name) })
+	s := &Scan{Hosts: merged.sortedHosts(), Findings: merged.findings, Domains: merged.domains, Stats: merged.stats}
+	err = (jsonWriter{}).Write(out, s)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	fmt.Fprintf(console, "Merged %d hosts from %d files\n", len(s.Hosts), len(files))
+	if *matrix {
+		printVisibility(console, s.Hosts, merged.sources)
+	}
+	return err
+}
+
+// printVisibility writes a table with a row per open or open|filtered port
+// and a column per source, marking where each service was reachable from
+// and in which state.
+func printVisibility(w io.Writer, hosts []*HostResult, sources []string) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "\nSERVICE\t%s\n", strings.Join(sources, "\t"))
+	for _, h := range hosts {
+		if h.quarantined() {
+			continue
+		}
+		for _, p := range h.Ports {
+			if p.State != "open" && p.State != "open|filtered" {
+				continue
+			}
+			service := net.JoinHostPort(h.IP, strconv.Itoa(p.Port))
+			if p.Service != "" {
+				service += " (" + p.Service + ")"
+			}
+			cells := make([]string, len(sources))
+			for i, src := range sources {
+				cells[i] = "-"
+				if slices.Contains(p.Sources, src) {
+					cells[i] = p.State
+				}
+			}
+			fmt.Fprintf(tw, "%s\t%s\n", service, strings.Join(cells, "\t"))
+		}
+	}
+	tw.Flush()
+}
+
+// resultMerge accumulates hosts from several result files.
+type resultMerge struct {
+	hosts    map[string]*HostResult
+	sources  []string // in the order they were first seen
+	stats    ScanStats
+	findings []Finding
+	domains  []ADDomain
+}
+
+// addFile reads a result file written with -o (JSON lines) or -oA (the
+// .json document), compressed or not, and merges its hosts, findings and
+// AD domains.
+func (m *resultMerge) addFile(name string) error {
+	f, err := openFile(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return m.add(f, name)
+}
+
+// add merges the results read from r, naming name as their source. A
+// host record in a JSON-lines stream replaces any earlier one for the same
+// address, see resultStream.
+func (m *resultMerge) add(r io.Reader, name string) error {
+	var hosts []*HostResult
+	index := make(map[string]int) // address to index in hosts
+	keep := func(h *HostResult) {
+		if i, ok := index[h.IP]; ok {
+			hosts[i] = h
+			return
+		}
+		index[h.IP] = len(hosts)
+		hosts = append(hosts, h)
+	}
+	dec := json.NewDecoder(r)
+	for {
+		var v struct {
+			HostResult
+			Version  *int          `json:"schema_version"`
+			Hosts    []*HostResult `json:"hosts"`
+			Findings []Finding     `json:"findings"`
+			Domains  []ADDomain    `json:"ad_domains"`
+			Stats    *ScanStats    `json:"stats"`
+		}
+		err := dec.Decode(&v)
+		if errors.Is(err, io.EOF) {
+			for _, h := range hosts {
+				m.addHost(h, name)
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		// Results written before versioning lack the field but have the
+		// layout of version 1.
+		if v.Version != nil && *v.Version != schemaVersion {
+			return fmt.Errorf("%s: unsupported schema_version %d; this build reads version %d", name, *v.Version, schemaVersion)
+		}
+		if v.IP != "" {
+			keep(&v.HostResult)
+		}
+		for _, h := range v.Hosts {
+			keep(h)
+		}
+		for _, f := range v.Findings {
+			m.addFinding(f, name)
+		}
+		for _, d := range v.Domains {
+			m.addDomain(d)
+		}
+		if v.Stats != nil {
+			m.stats.add(v.Stats)
+		}
+	}
+}
+
+// addHost merges h, as reported by source, into the results. Results that
+// were merged before keep their own sources.
+func (m *resultMerge) addHost(h *HostResult, source string) {
+	if m.hosts == nil {
+		m.hosts = make(map[string]*HostResult)
+	}
+	sources := h.Sources
+	if len(sources) == 0 {
+		sources = []string{source}
+	}
+
+	cur := m.hosts[h.IP]
+	if cur == nil {
+		cur = &HostResult{IP: h.IP, Method: h.Method, RTT: h.RTT}
+		m.hosts[h.IP] = cur
+	}
+	cur.Sources = appendNew(cur.Sources, sources...)
+	m.sources = appendNew(m.sources, sources...)
+	// Fill in details the earlier sources did not have.
+	if cur.MAC == "" {
+		cur.MAC = h.MAC
+	}
+	if cur.Hostname == "" {
+		cur.Hostname = h.Hostname
+	}
+	cur.Names = appendNew(cur.Names, h.Names...)
+	if cur.NetBIOS == "" {
+		cur.NetBIOS = h.NetBIOS
+	}
+	if cur.Device == "" {
+		cur.Device = h.Device
+	}
+	if cur.Role == "" || cur.Role == "unknown" {
+		cur.Role = h.Role
+	}
+	// A host any vantage point saw as a honeypot stays quarantined.
+	cur.Honeypot = appendNew(cur.Honeypot, h.Honeypot...)
+	if cur.quarantined() {
+		cur.Role = "honeypot"
+	}
+
+	for _, p := range h.Ports {
+		psources := p.Sources
+		if len(psources) == 0 {
+			psources = sources
+		}
+		i := slices.IndexFunc(cur.Ports, func(q PortResult) bool { return q.Port == p.Port })
+		switch {
+		case i < 0:
+			p.Sources = slices.Clone(psources)
+			cur.Ports = append(cur.Ports, p)
+		case cur.Ports[i].State == p.State:
+			cur.Ports[i].Sources = appendNew(cur.Ports[i].Sources, psources...)
+			if cur.Ports[i].Banner == "" {
+				cur.Ports[i].Banner = p.Banner
+			}
+		case p.State == "open":
+			// A port one vantage point reaches is open, whatever the
+			// others saw; the sources say where it was seen from.
+			p.Sources = slices.Clone(psources)
+			cur.Ports[i] = p
+		}
+	}
+	cur.sortPorts()
+}
+
+// addFinding merges f, as reported by source, into the findings. The same
+// finding from another input only adds to its sources.
+func (m *resultMerge) addFinding(f Finding, source string) {
+	sources := f.Sources
+	if len(sources) == 0 {
+		sources = []string{source}
+	}
+	i := slices.IndexFunc(m.findings, func(cur Finding) bool {
+		return cur.IP == f.IP && cur.Port == f.Port && cur.Service == f.Service &&
+			cur.Severity == f.Severity && cur.Detail == f.Detail
+	})
+	if i < 0 {
+		f.Sources = slices.Clone(sources)
+		m.findings = append(m.findings, f)
+		return
+	}
+	m.findings[i].Sources = appendNew(m.findings[i].Sources, sources...)
+}
+
+// addDomain merges what another input learned about an AD domain into
+// the domain of the same name.
+func (m *resultMerge) addDomain(d ADDomain) {
+	i := slices.IndexFunc(m.domains, func(cur ADDomain) bool { return cur.Name == d.Name })
+	if i < 0 {
+		m.domains = append(m.domains, d)
+		return
+	}
+	cur := &m.domains[i]
+	cur.Found = appendNew(cur.Found, d.Found...)
+	cur.DCs = appendNew(cur.DCs, d.DCs...)
+	cur.GCs = appendNew(cur.GCs, d.GCs...)
+}
+
+func (m *resultMerge) sortedHosts() []*HostResult {
+	hosts := make([]*HostResult, 0, len(m.hosts))
+	for _, h := range m.hosts {
+		hosts = append(hosts, h)
+	}
+	sortHosts(hosts)
+	return hosts
+}
+
+// appendNew appends the values of add that list does not contain yet.
+func appendNew(list []string, add ...string) []string {
+	for _, v := range add {
+		if !slices.Contains(list, v) {
+			list = append(list, v)
+		}
+	}
+	return list
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMergeReadsJSONLines(t *testing.T) {
+	s := &Scan{
+		Hosts: []*HostResult{
+			{IP: "10.0.0.5", Method: "icmp", Ports: []PortResult{{Port: 389, State: "open", Service: "ldap"}}},
+		},
+		Findings: []Finding{{IP: "10.0.0.5", Port: 23, Service: "telnet", Severity: "high", Detail: "telnet"}},
+		Domains:  []ADDomain{{Name: "corp.example.com", Forest: "example.com", Found: []string{"10.0.0.5 dc1.corp.example.com"}}},
+		Stats:    ScanStats{HostsUp: 1, PortsOpen: 1, Errors: map[string]int{}},
+	}
+	name := filepath.Join(t.TempDir(), "scan.jsonl")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (jsonLinesWriter{}).Write(f, s); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	var m resultMerge
+	if err := m.addFile(name); err != nil {
+		t.Fatalf("addFile: %v", err)
+	}
+	hosts := m.sortedHosts()
+	if len(hosts) != 1 || hosts[0].IP != "10.0.0.5" || len(hosts[0].Ports) != 1 {
+		t.Errorf("merged hosts = %+v, want 10.0.0.5 with port 389", hosts)
+	}
+	if len(m.findings) != 1 || m.findings[0].Service != "telnet" {
+		t.Errorf("merged findings = %+v, want the telnet finding", m.findings)
+	}
+	if len(m.domains) != 1 || m.domains[0].Name != "corp.example.com" ||
+		!slices.Equal(m.domains[0].Found, s.Domains[0].Found) {
+		t.Errorf("merged domains = %+v, want corp.example.com", m.domains)
+	}
+	if m.stats.HostsUp != 1 || m.stats.PortsOpen != 1 {
+		t.Errorf("merged stats = %+v, want the scan's counts", m.stats)
+	}
+}
+
+// Two vantage points: the outside sees 80 filtered where the inside
+// reaches it, both reach 22, and only the inside sees the honeypot.
+const (
+	mergeOutside = `{"hosts":[
+		{"ip":"10.0.0.1","method":"icmp","rtt_ns":1000,"ports":[
+			{"port":22,"state":"open","service":"ssh","latency_ns":1},
+			{"port":80,"state":"filtered","latency_ns":1}]}],
+	"stats":{"hosts_up":1}}`
+	mergeInside = `{"ip":"10.0.0.1","method":"arp","rtt_ns":10,"mac":"00:11:22:33:44:55","ports":[
+		{"port":22,"state":"open","service":"ssh","banner":"SSH-2.0-OpenSSH_9.6","latency_ns":1},
+		{"port":80,"state":"open","service":"http","latency_ns":1},
+		{"port":443,"state":"filtered","latency_ns":1}]}
+	{"ip":"10.0.0.9","method":"arp","rtt_ns":10,"honeypot":["all 20 scanned ports are open"],"ports":[
+		{"port":21,"state":"open","latency_ns":1}]}
+	{"stats":{"hosts_up":2}}`
+)
+
+func mergeInputs(t *testing.T) *resultMerge {
+	t.Helper()
+	var m resultMerge
+	if err := m.add(strings.NewReader(mergeOutside), "outside.json"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.add(strings.NewReader(mergeInside), "inside.jsonl"); err != nil {
+		t.Fatal(err)
+	}
+	return &m
+}
+
+func TestMergeHosts(t *testing.T) {
+	m := mergeInputs(t)
+	hosts := m.sortedHosts()
+	if len(hosts) != 2 {
+		t.Fatalf("merged %d hosts, want 2", len(hosts))
+	}
+	h := hosts[0]
+	if h.IP != "10.0.0.1" || h.Method != "icmp" || h.MAC != "00:11:22:33:44:55" {
+		t.Errorf("host = %s method %s MAC %q, want 10.0.0.1 from the first input with the second's MAC", h.IP, h.Method, h.MAC)
+	}
+	if want := []string{"outside.json", "inside.jsonl"}; !slices.Equal(h.Sources, want) {
+		t.Errorf("host sources = %v, want %v", h.Sources, want)
+	}
+	want := []struct {
+		port    int
+		state   string
+		sources []string
+	}{
+		{22, "open", []string{"outside.json", "inside.jsonl"}},
+		{80, "open", []string{"inside.jsonl"}},
+		{443, "filtered", []string{"inside.jsonl"}},
+	}
+	if len(h.Ports) != len(want) {
+		t.Fatalf("ports = %+v, want %d", h.Ports, len(want))
+	}
+	for i, w := range want {
+		p := h.Ports[i]
+		if p.Port != w.port || p.State != w.state || !slices.Equal(p.Sources, w.sources) {
+			t.Errorf("port %d %s from %v, want %d %s from %v", p.Port, p.State, p.Sources, w.port, w.state, w.sources)
+		}
+	}
+	if h.Ports[0].Banner == "" {
+		t.Error("port 22 lost the banner only the second input had")
+	}
+
+	if hp := hosts[1]; !hp.quarantined() || hp.Role != "honeypot" {
+		t.Errorf("10.0.0.9 honeypot %v role %q, want it quarantined", hp.Honeypot, hp.Role)
+	}
+	if m.stats.HostsUp != 3 {
+		t.Errorf("merged hosts_up = %d, want the sum 3", m.stats.HostsUp)
+	}
+}
+
+func TestMergeOpenWinsInEitherOrder(t *testing.T) {
+	var m resultMerge
+	for _, in := range []struct{ name, data string }{{"inside.jsonl", mergeInside}, {"outside.json", mergeOutside}} {
+		if err := m.add(strings.NewReader(in.data), in.name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p := m.sortedHosts()[0].Ports[1]
+	if p.Port != 80 || p.State != "open" || !slices.Equal(p.Sources, []string{"inside.jsonl"}) {
+		t.Errorf("port 80 = %s from %v, want open from inside.jsonl", p.State, p.Sources)
+	}
+}
+
+func TestVisibilityMatrix(t *testing.T) {
+	m := mergeInputs(t)
+	var b strings.Builder
+	printVisibility(&b, m.sortedHosts(), m.sources)
+	var rows [][]string
+	for _, line := range strings.Split(strings.TrimSpace(b.String()), "\n") {
+		rows = append(rows, strings.Fields(line))
+	}
+	// Filtered ports and the quarantined host have no rows.
+	want := [][]string{
+		{"SERVICE", "outside.json", "inside.jsonl"},
+		{"10.0.0.1:22", "(ssh)", "open", "open"},
+		{"10.0.0.1:80", "(http)", "-", "open"},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("matrix:\n%s\nwant %d rows", b.String(), len(want))
+	}
+	for i := range want {
+		if !slices.Equal(rows[i], want[i]) {
+			t.Errorf("matrix row %d = %v, want %v", i, rows[i], want[i])
+		}
+	}
+}
+
+func TestMergeSchemaVersion(t *testing.T) {
+	tests := []struct {
+		input   string
+		wantErr bool
+	}{
+		{`{"schema_version":1,"hosts":[],"stats":{}}`, false},
+		{"{\"schema_version\":1}\n{\"stats\":{}}", false},
+		{`{"hosts":[],"stats":{}}`, false}, // written before versioning
+		{`{"schema_version":2,"hosts":[],"stats":{}}`, true},
+		{"{\"schema_version\":0}\n{\"stats\":{}}", true},
+	}
+	for _, tt := range tests {
+		var m resultMerge
+		if err := m.add(strings.NewReader(tt.input), "in.json"); (err != nil) != tt.wantErr {
+			t.Errorf("merging %s: error = %v, want error %v", tt.input, err, tt.wantErr)
+		}
+	}
+}
+
+func TestMergeKeepsLastStreamedRecord(t *testing.T) {
+	// A streamed scan writes the host as discovery finishes it and again
+	// once the enrichment phases added to it.
+	var buf strings.Builder
+	out := newResultStream(&buf)
+	h := &HostResult{IP: "10.0.0.7", Method: "icmp", Ports: []PortResult{{Port: 80, State: "open"}}}
+	out.host(h)
+	out.host(h)
+	h.Ports[0].Service = "http"
+	if err := out.finish(&Scan{Hosts: []*HostResult{h}, Stats: ScanStats{HostsUp: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(buf.String(), `"ip":"10.0.0.7"`); n != 2 {
+		t.Errorf("stream wrote the host %d times, want 2 (unchanged records are not repeated):\n%s", n, buf.String())
+	}
+
+	var m resultMerge
+	if err := m.add(strings.NewReader(buf.String()), "scan.jsonl"); err != nil {
+		t.Fatal(err)
+	}
+	hosts := m.sortedHosts()
+	if len(hosts) != 1 || len(hosts[0].Ports) != 1 || hosts[0].Ports[0].Service != "http" {
+		t.Errorf("merged hosts = %+v, want 10.0.0.7 with the http port of the last record", hosts)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"strings"
+	"time"
+)
+
+// nbstatQuery builds a NetBIOS node status request for the wildcard name,
+// which any Windows or Samba host answers with its name table.
+func nbstatQuery(id uint16) []byte {
+	b := make([]byte, 12, 50)
+	binary.BigEndian.PutUint16(b[0:2], id)
+	binary.BigEndian.PutUint16(b[4:6], 1) // one question
+	// "*" padded with NULs to 16 bytes, in first-level encoding: every
+	// nibble becomes a letter from 'A'.
+	b = append(b, 32)
+	name := [16]byte{'*'}
+	for _, c := range name {
+		b = append(b, 'A'+c>>4, 'A'+c&0x0f)
+	}
+	b = append(b, 0)
+	b = binary.BigEndian.AppendUint16(b, 0x21) // NBSTAT
+	b = binary.BigEndian.AppendUint16(b, 1)    // IN
+	return b
+}
+
+// netbiosName queries ip on UDP port 137 and returns its name as
+// "WORKGROUP\MACHINE". An empty name without an error means no answer.
+func netbiosName(ip string, timeout time.Duration) (string, error) {
+	c, err := net.Dial("udp", net.JoinHostPort(ip, "137"))
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+
+	id := uint16(rand.N(0x10000))
+	query := nbstatQuery(id)
+	if _, err := c.Write(query); err != nil {
+		// An ICMP unreachable from an earlier packet can surface here.
+		return "", nil
+	}
+	probeBytes.sent.Add(int64(len(query)))
+
+	c.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 1500)
+	for {
+		n, err := c.Read(buf)
+		if err != nil {
+			return "", nil
+		}
+		probeBytes.received.Add(int64(n))
+		if n < 12 || binary.BigEndian.Uint16(buf[0:2]) != id {
+			continue
+		}
+		return parseNBSTAT(buf[:n])
+	}
+}
+
+// parseNBSTAT picks the machine and workgroup names out of a node status
+// response.
+func parseNBSTAT(b []byte) (string, error) {
+	if len(b) < 12 {
+		return "", fmt.Errorf("truncated NBSTAT response")
+	}
+	if binary.BigEndian.Uint16(b[6:8]) == 0 {
+		return "", fmt.Errorf("NBSTAT response without answer")
+	}
+	i := 12
+	// Skip the answer name: labels, or a compression pointer.
+	for i < len(b) && b[i] != 0 {
+		if b[i]&0xc0 == 0xc0 {
+			i++
+			break
+		}
+		i += int(b[i]) + 1
+	}
+	i++
+	// Type, class, TTL and data length precede the name count.
+	i += 10
+	if i >= len(b) {
+		return "", fmt.Errorf("truncated NBSTAT response")
+	}
+	count := int(b[i])
+	i++
+
+	var machine, group string
+	for ; count > 0 && i+18 <= len(b); count, i = count-1, i+18 {
+		name := strings.TrimRight(string(b[i:i+15]), " \x00")
+		suffix := b[i+15]
+		isGroup := b[i+16]&0x80 != 0
+		// Suffix 0x00 is the workstation service: the machine name when
+		// unique, the workgroup or domain when a group.
+		if suffix != 0 {
+			continue
+		}
+		if isGroup && group == "" {
+			group = name
+		} else if !isGroup && machine == "" {
+			machine = name
+		}
+	}
+	if machine == "" {
+		return "", fmt.Errorf("NBSTAT response without a machine name")
+	}
+	if group == "" {
+		return machine, nil
+	}
+	return group + `\` + machine, nil
+}
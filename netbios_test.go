@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// nbstatResponse builds a node status response naming machine and group.
+func nbstatResponse(machine, group string) []byte {
+	b := make([]byte, 12, 128)
+	binary.BigEndian.PutUint16(b[6:8], 1)
+	b = append(b, 32)
+	b = append(b, "CKAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"...)
+	b = append(b, 0, 0, 0x21, 0, 1, 0, 0, 0, 0, 0, 0, 2)
+	entry := func(name string, flags byte) {
+		padded := make([]byte, 15)
+		copy(padded, name+"               ")
+		b = append(b, padded...)
+		b = append(b, 0, flags, 0)
+	}
+	entry(machine, 0x04)
+	entry(group, 0x84)
+	return b
+}
+
+func FuzzParseNBSTAT(f *testing.F) {
+	f.Add(nbstatResponse("FILESRV", "CORP"))
+	f.Add([]byte{})
+	f.Add(make([]byte, 12))
+	f.Add([]byte{0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0xc0, 0x0c})
+	f.Fuzz(func(t *testing.T, b []byte) {
+		name, err := parseNBSTAT(b)
+		if err == nil && name == "" {
+			t.Errorf("parseNBSTAT(%x) returned an empty name without an error", b)
+		}
+	})
+}
+
+func TestParseNBSTAT(t *testing.T) {
+	name, err := parseNBSTAT(nbstatResponse("FILESRV", "CORP"))
+	if err != nil || name != `CORP\FILESRV` {
+		t.Errorf(`parseNBSTAT = %q, %v, want "CORP\FILESRV"`, name, err)
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"io"
+	"os"
+)
+
+// console receives human-readable progress and summaries. It is switched to
+// stderr when structured results are written to stdout, so the two never
+// mix in a pipeline.
+var console io.Writer = os.Stdout
+
+// openOutput returns the destination for structured results: stdout for
+// "-", otherwise the named file, truncated and compressed if its name ends
+// in ".zst".
+func openOutput(name string) (io.WriteCloser, error) {
+	if name == "-" {
+		console = os.Stderr
+		return nopCloser{os.Stdout}, nil
+	}
+	return createFile(name)
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
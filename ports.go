@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// parsePorts parses a port specification: a comma-separated list of ports,
+// inclusive ranges and service names, e.g. "ssh,80,https,8000-8100". The
+// result is sorted and free of duplicates.
+func parsePorts(spec string) ([]int, error) {
+	seen := make(map[int]bool)
+	var ports []int
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			return nil, fmt.Errorf("empty entry in port list %q", spec)
+		}
+
+		// Service names may contain dashes (http-alt), so try them first.
+		if port, ok := servicePort(field); ok {
+			if !seen[port] {
+				seen[port] = true
+				ports = append(ports, port)
+			}
+			continue
+		}
+
+		lo, hi, isRange := strings.Cut(field, "-")
+		start, err := parsePort(lo)
+		if err != nil {
+			return nil, err
+		}
+		end := start
+		if isRange {
+			if end, err = parsePort(hi); err != nil {
+				return nil, err
+			}
+			if start > end {
+				return nil, fmt.Errorf("port range %q runs backwards", field)
+			}
+		}
+
+		for p := start; p <= end; p++ {
+			if !seen[p] {
+				seen[p] = true
+				ports = append(ports, p)
+			}
+		}
+	}
+	sort.Ints(ports)
+	return ports, nil
+}
+
+func parsePort(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	port, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid port %q: not a number or known service name", s)
+	}
+	if port < 1 || port > 65535 {
+		return 0, fmt.Errorf("port %d out of range 1-65535", port)
+	}
+	return port, nil
+}
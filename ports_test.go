@@ -0,0 +1,54 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParsePorts(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    []int
+		wantErr string // substring of the error, if one is expected
+	}{
+		{spec: "80", want: []int{80}},
+		{spec: "22,80,443", want: []int{22, 80, 443}},
+		{spec: "1-5", want: []int{1, 2, 3, 4, 5}},
+		{spec: "65535", want: []int{65535}},
+		{spec: "1", want: []int{1}},
+		{spec: "ssh,http,https", want: []int{22, 80, 443}},
+		{spec: "HTTPS", want: []int{443}},
+		{spec: "http-alt", want: []int{8000}},
+		{spec: "ssh,80,https,8000-8002", want: []int{22, 80, 443, 8000, 8001, 8002}},
+		{spec: " 22 , 80 ", want: []int{22, 80}},
+		{spec: "443,80,22", want: []int{22, 80, 443}},
+		{spec: "80,80,http,79-81", want: []int{79, 80, 81}},
+		{spec: "5-5", want: []int{5}},
+
+		{spec: "", wantErr: "empty entry"},
+		{spec: "22,,80", wantErr: "empty entry"},
+		{spec: "22,", wantErr: "empty entry"},
+		{spec: "100-90", wantErr: "runs backwards"},
+		{spec: "0", wantErr: "out of range"},
+		{spec: "65536", wantErr: "out of range"},
+		{spec: "0-10", wantErr: "out of range"},
+		{spec: "65530-65536", wantErr: "out of range"},
+		{spec: "-1", wantErr: "not a number"},
+		{spec: "gopher-plus", wantErr: "not a number or known service name"},
+		{spec: "ssh,nosuchservice", wantErr: `"nosuchservice"`},
+		{spec: "1-", wantErr: "not a number"},
+	}
+	for _, tt := range tests {
+		got, err := parsePorts(tt.spec)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parsePorts(%q) = %v, %v; want an error containing %q", tt.spec, got, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(got, tt.want) {
+			t.Errorf("parsePorts(%q) = %v, %v; want %v", tt.spec, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// rateLimiter spaces probes evenly so that no more than a given number
+// start per second across all workers. The zero value does not limit.
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+	probes   atomic.Int64 // calls to wait, for the achieved rate
+}
+
+// probeRate throttles every probe the scan sends, see -max-rate: each
+// discovery and port probe, name lookup and connection of the enrichment
+// phases waits for it first.
+var probeRate rateLimiter
+
+// setRate limits probes to perSecond; zero or less removes the limit.
+func (r *rateLimiter) setRate(perSecond float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.interval = 0
+	if perSecond > 0 {
+		r.interval = time.Duration(float64(time.Second) / perSecond)
+	}
+}
+
+// wait blocks until the caller may send its next probe.
+func (r *rateLimiter) wait() {
+	r.probes.Add(1)
+	r.mu.Lock()
+	if r.interval == 0 {
+		r.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	slot := now
+	if r.next.After(now) {
+		slot = r.next
+	}
+	r.next = slot.Add(r.interval)
+	r.mu.Unlock()
+	time.Sleep(slot.Sub(now))
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"time"
+)
+
+// portProbe probes one TCP port and classifies it.
+type portProbe func(ip string, port int, timeout time.Duration) (PortResult, error)
+
+// scanTypes maps the -scan names to their probes.
+var scanTypes = map[string]portProbe{
+	"connect": scanPort,
+	"syn":     synScanPort,
+	"fin":     stealthProbe(tcpFIN),
+	"null":    stealthProbe(0),
+	"xmas":    stealthProbe(tcpFIN | tcpPSH | tcpURG),
+	"ack":     ackScanPort,
+}
+
+// TCP header flags.
+const (
+	tcpFIN = 0x01
+	tcpSYN = 0x02
+	tcpRST = 0x04
+	tcpPSH = 0x08
+	tcpACK = 0x10
+	tcpURG = 0x20
+)
+
+// synScanPort sends a bare SYN and classifies the port by the answer: a
+// SYN/ACK means open, a RST closed and silence filtered. The handshake is
+// never completed; the local kernel resets it since no socket owns it.
+func synScanPort(ip string, port int, timeout time.Duration) (PortResult, error) {
+	if net.ParseIP(ip).To4() == nil {
+		// Raw probes are IPv4 only.
+		return scanPort(ip, port, timeout)
+	}
+	flags, rtt, err := rawTCPProbe(ip, port, tcpSYN, timeout)
+	result := PortResult{Port: port, Latency: rtt, State: "filtered"}
+	if err != nil {
+		return result, err
+	}
+	switch {
+	case flags&(tcpSYN|tcpACK) == tcpSYN|tcpACK:
+		result.State = "open"
+		result.Service = serviceName(port)
+	case flags&tcpRST != 0:
+		result.State = "closed"
+	}
+	return result, nil
+}
+
+// stealthProbe returns a probe sending a segment with flags but without
+// SYN or ACK. RFC 793 stacks answer it with a RST on closed ports and drop
+// it on open ones, so silence means open|filtered rather than open. Stacks
+// that reset regardless, notably Windows, show every port closed.
+func stealthProbe(flags byte) portProbe {
+	return func(ip string, port int, timeout time.Duration) (PortResult, error) {
+		if net.ParseIP(ip).To4() == nil {
+			return scanPort(ip, port, timeout)
+		}
+		reply, rtt, err := rawTCPProbe(ip, port, flags, timeout)
+		result := PortResult{Port: port, Latency: rtt, State: "open|filtered", Service: serviceName(port)}
+		if reply&tcpRST != 0 {
+			result.State, result.Service = "closed", ""
+		}
+		return result, err
+	}
+}
+
+// ackScanPort sends a bare ACK, which does not belong to any connection.
+// Hosts answer it with a RST whether the port is open or closed, so it
+// tells nothing about services, but a stateful firewall drops it: a RST
+// means unfiltered, silence filtered.
+func ackScanPort(ip string, port int, timeout time.Duration) (PortResult, error) {
+	if net.ParseIP(ip).To4() == nil {
+		return scanPort(ip, port, timeout)
+	}
+	reply, rtt, err := rawTCPProbe(ip, port, tcpACK, timeout)
+	result := PortResult{Port: port, Latency: rtt, State: "filtered"}
+	if reply&tcpRST != 0 {
+		result.State = "unfiltered"
+	}
+	return result, err
+}
+
+// rawTCPProbe sends one TCP segment with the given flags to ip:port and
+// returns the flags of the answer, or 0 if none arrived within timeout.
+func rawTCPProbe(ip string, port int, flags byte, timeout time.Duration) (byte, time.Duration, error) {
+	dst := net.ParseIP(ip).To4()
+	src, err := sourceAddr(dst)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	c, err := net.ListenPacket("ip4:tcp", src.String())
+	if err != nil {
+		return 0, 0, fmt.Errorf("creating raw TCP socket: %w", err)
+	}
+	defer c.Close()
+
+	sport := 32768 + rand.IntN(28232)
+	seq := rand.Uint32()
+	segment := tcpSegment(src, dst, sport, port, seq, flags)
+
+	start := time.Now()
+	if _, err := c.WriteTo(segment, &net.IPAddr{IP: dst}); err != nil {
+		return 0, 0, fmt.Errorf("sending TCP probe: %w", err)
+	}
+	probeBytes.sent.Add(int64(len(segment)))
+
+	// The socket sees every TCP segment arriving at this host, so wait for
+	// one from the probed port to our source port.
+	c.SetReadDeadline(time.Now().Add(timeout))
+	reply := make([]byte, 1500)
+	for {
+		n, peer, err := c.ReadFrom(reply)
+		if err != nil {
+			return 0, time.Since(start), nil
+		}
+		if n < 20 || !peerIP(peer).Equal(dst) {
+			continue
+		}
+		if int(binary.BigEndian.Uint16(reply[0:2])) != port || int(binary.BigEndian.Uint16(reply[2:4])) != sport {
+			continue
+		}
+		probeBytes.received.Add(int64(n))
+		return reply[13], time.Since(start), nil
+	}
+}
+
+// sourceAddr returns the local address the kernel would use to reach dst,
+// which the TCP checksum covers. Connecting a UDP socket sends nothing.
+func sourceAddr(dst net.IP) (net.IP, error) {
+	c, err := net.Dial("udp4", net.JoinHostPort(dst.String(), "9"))
+	if err != nil {
+		return nil, fmt.Errorf("finding source address: %w", err)
+	}
+	defer c.Close()
+	return c.LocalAddr().(*net.UDPAddr).IP.To4(), nil
+}
+
+// tcpSegment builds a 20 byte TCP header without options or payload.
+func tcpSegment(src, dst net.IP, sport, dport int, seq uint32, flags byte) []byte {
+	b := make([]byte, 20)
+	binary.BigEndian.PutUint16(b[0:2], uint16(sport))
+	binary.BigEndian.PutUint16(b[2:4], uint16(dport))
+	binary.BigEndian.PutUint32(b[4:8], seq)
+	b[12] = 5 << 4 // data offset in 32-bit words
+	b[13] = flags
+	binary.BigEndian.PutUint16(b[14:16], 1024) // window
+	binary.BigEndian.PutUint16(b[16:18], tcpChecksum(src, dst, b))
+	return b
+}
+
+// tcpChecksum computes the checksum over the IPv4 pseudo header and the
+// segment.
+func tcpChecksum(src, dst net.IP, segment []byte) uint16 {
+	pseudo := make([]byte, 0, 12+len(segment))
+	pseudo = append(pseudo, src...)
+	pseudo = append(pseudo, dst...)
+	pseudo = append(pseudo, 0, 6)
+	pseudo = binary.BigEndian.AppendUint16(pseudo, uint16(len(segment)))
+	pseudo = append(pseudo, segment...)
+	if len(pseudo)%2 == 1 {
+		pseudo = append(pseudo, 0)
+	}
+
+	var sum uint32
+	for i := 0; i < len(pseudo); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(pseudo[i:]))
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,13 @@
+package main
+
+import "net"
+
+// checkRawTCP reports whether raw TCP sockets can be opened, which needs
+// root or CAP_NET_RAW.
+func checkRawTCP() error {
+	c, err := net.ListenPacket("ip4:tcp", "0.0.0.0")
+	if err != nil {
+		return err
+	}
+	return c.Close()
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// checkRawTCP always fails off Linux: the BSD and macOS kernels never pass
+// inbound TCP to raw sockets and Windows refuses to send raw TCP, so the
+// probes would see every port filtered. Scans and TCP pings fall back to
+// connect attempts instead.
+func checkRawTCP() error {
+	return errors.New("raw TCP probes are only supported on Linux")
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// reachCheck repeatedly tests that one TCP port is reachable, from a given
+// source address if set, and reports every change of state: a TCP-level
+// smokeping for debugging flaky firewalls and services.
+type reachCheck struct {
+	Host     string
+	Port     int
+	Source   string // local address to connect from, if set
+	Interval time.Duration
+	Timeout  time.Duration
+	Count    int // stop after this many probes, 0 runs until interrupted
+}
+
+func (c *reachCheck) Run() error {
+	dialer := net.Dialer{Timeout: c.Timeout}
+	if c.Source != "" {
+		ip := net.ParseIP(c.Source)
+		if ip == nil {
+			return fmt.Errorf("invalid source address %q", c.Source)
+		}
+		dialer.LocalAddr = &net.TCPAddr{IP: ip}
+	}
+	target := net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt)
+	defer signal.Stop(stop)
+
+	fmt.Fprintf(console, "Probing %s every %v\n", target, c.Interval)
+	var probes, open, flaps int
+	last := ""
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+	for c.Count == 0 || probes < c.Count {
+		state, rtt, err := reachProbe(&dialer, target)
+		if err != nil {
+			return err
+		}
+		probes++
+		if state == "open" {
+			open++
+		}
+		now := time.Now().Format("2006-01-02T15:04:05.000Z07:00")
+		switch {
+		case last == "":
+			fmt.Fprintf(console, "%s %s %s (%v)\n", now, target, state, rtt.Round(time.Microsecond))
+		case state != last:
+			flaps++
+			fmt.Fprintf(console, "%s %s %s -> %s (%v)\n", now, target, last, state, rtt.Round(time.Microsecond))
+		}
+		last = state
+
+		if c.Count != 0 && probes == c.Count {
+			break
+		}
+		select {
+		case <-ticker.C:
+		case <-stop:
+			c.Count = probes
+		}
+	}
+	fmt.Fprintf(console, "\n%d probes, %d open (%.1f%%), %d state changes\n",
+		probes, open, 100*float64(open)/float64(max(probes, 1)), flaps)
+	return nil
+}
+
+// reachProbe classifies one connection attempt like scanPort does.
+func reachProbe(d *net.Dialer, target string) (string, time.Duration, error) {
+	start := time.Now()
+	conn, err := d.Dial("tcp", target)
+	rtt := time.Since(start)
+	switch {
+	case err == nil:
+		conn.Close()
+		return "open", rtt, nil
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "closed", rtt, nil
+	case isLocalError(err):
+		return "", rtt, err
+	}
+	return "filtered", rtt, nil
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"net/netip"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HostResult is everything learned about one host during a scan.
+type HostResult struct {
+	IP       string        `json:"ip" xml:"ip,attr"`
+	Method   string        `json:"method" xml:"method,attr"` // discovery probe the host answered, e.g. "icmp"
+	RTT      time.Duration `json:"rtt_ns" xml:"rtt_ns,attr"` // round trip of that probe
+	MAC      string        `json:"mac,omitempty" xml:"mac,attr,omitempty"`
+	Hostname string        `json:"hostname,omitempty" xml:"hostname,attr,omitempty"`
+	NetBIOS  string        `json:"netbios,omitempty" xml:"netbios,attr,omitempty"`
+	Names    []string      `json:"mdns,omitempty" xml:"mdns,omitempty"`      // service instances announced over mDNS
+	Device   string        `json:"upnp,omitempty" xml:"upnp,attr,omitempty"` // UPnP friendly name and model
+	Role     string        `json:"role,omitempty" xml:"role,attr,omitempty"` // likely kind of machine, see inferRole
+	Honeypot []string      `json:"honeypot,omitempty" xml:"honeypot"`        // why its ports look fake; they are quarantined if set
+	Sources  []string      `json:"sources,omitempty" xml:"source,omitempty"` // result files this host came from, set by merge
+	Ports    []PortResult  `json:"ports" xml:"port"`                         // ports in listedStates, sorted by number
+}
+
+// PortResult is the outcome of probing one TCP port.
+type PortResult struct {
+	Port    int           `json:"port" xml:"number,attr"`
+	State   string        `json:"state" xml:"state,attr"` // "open", "closed", "filtered", "open|filtered" or "unfiltered"
+	Service string        `json:"service,omitempty" xml:"service,attr,omitempty"`
+	Banner  string        `json:"banner,omitempty" xml:"banner,omitempty"`
+	Latency time.Duration `json:"latency_ns" xml:"latency_ns,attr"`
+	VHosts  []VHost       `json:"vhosts,omitempty" xml:"vhost,omitempty"`
+	Notes   []string      `json:"notes,omitempty" xml:"note,omitempty"`     // audit findings, e.g. no STARTTLS
+	Sources []string      `json:"sources,omitempty" xml:"source,omitempty"` // set by merge
+}
+
+// listedStates are the port states kept on a host, in the order the
+// summary lists them. FIN, NULL and XMAS scans never see a port as plainly
+// open, so theirs are kept too, and ACK scans map the firewall port by
+// port. Filtered ports are kept for ACK scans only, see newAggregator, and
+// closed ones never; they would hold hosts x ports of results.
+var listedStates = []string{"open", "open|filtered", "unfiltered", "filtered"}
+
+// quarantined reports whether h looks like a honeypot, whose ports the
+// report and the enrichment phases do not take for real services.
+func (h *HostResult) quarantined() bool {
+	return len(h.Honeypot) > 0
+}
+
+// OpenPorts returns the numbers of the host's open ports.
+func (h *HostResult) OpenPorts() []int {
+	return h.PortsIn("open")
+}
+
+// PortsIn returns the numbers of the host's ports in any of states.
+func (h *HostResult) PortsIn(states ...string) []int {
+	var ports []int
+	for _, p := range h.Ports {
+		if slices.Contains(states, p.State) {
+			ports = append(ports, p.Port)
+		}
+	}
+	return ports
+}
+
+func (h *HostResult) sortPorts() {
+	sort.Slice(h.Ports, func(i, j int) bool { return h.Ports[i].Port < h.Ports[j].Port })
+}
+
+// sortHosts orders hosts by address.
+func sortHosts(hosts []*HostResult) {
+	sort.Slice(hosts, func(i, j int) bool {
+		a, errA := netip.ParseAddr(hosts[i].IP)
+		b, errB := netip.ParseAddr(hosts[j].IP)
+		if errA != nil || errB != nil {
+			return hosts[i].IP < hosts[j].IP
+		}
+		return a.Less(b)
+	})
+}
+
+// compareAddrs orders two addresses numerically, falling back to their
+// text if either does not parse.
+func compareAddrs(a, b string) int {
+	x, errX := netip.ParseAddr(a)
+	y, errY := netip.ParseAddr(b)
+	if errX != nil || errY != nil {
+		return strings.Compare(a, b)
+	}
+	return x.Compare(y)
+}
+
+// label returns the host's address decorated with whatever identifying
+// details were discovered.
+func (h *HostResult) label() string {
+	var extra []string
+	if h.Hostname != "" {
+		extra = append(extra, h.Hostname)
+	}
+	if h.NetBIOS != "" {
+		extra = append(extra, h.NetBIOS)
+	}
+	if h.MAC != "" {
+		extra = append(extra, h.MAC)
+	}
+	extra = append(extra, h.Names...)
+	if h.Device != "" {
+		extra = append(extra, h.Device)
+	}
+	if len(extra) == 0 {
+		return h.IP
+	}
+	return fmt.Sprintf("%s (%s)", h.IP, strings.Join(extra, ", "))
+}
+
+// formatPorts lists sorted port numbers in brackets, collapsing runs of
+// three or more consecutive ports into ranges, e.g. "[22 80-82 443]".
+func formatPorts(ports []int) string {
+	var parts []string
+	for i := 0; i < len(ports); {
+		j := i
+		for j+1 < len(ports) && ports[j+1] == ports[j]+1 {
+			j++
+		}
+		switch {
+		case j-i >= 2:
+			parts = append(parts, fmt.Sprintf("%d-%d", ports[i], ports[j]))
+		default:
+			for _, p := range ports[i : j+1] {
+				parts = append(parts, strconv.Itoa(p))
+			}
+		}
+		i = j + 1
+	}
+	return "[" + strings.Join(parts, " ") + "]"
+}
+
+// printSummary writes the end-of-scan report.
+func printSummary(w io.Writer, hosts []*HostResult, errs []errorEvent) {
+	fmt.Fprintf(w, "\nScan Summary:\n")
+	fmt.Fprintf(w, "Total active hosts found: %d\n", len(hosts))
+	printRoles(w, hosts)
+	groups := groupByDomain(hosts)
+	for _, g := range groups {
+		// Scans without names, or of a single zone, need no headings.
+		if len(groups) > 1 {
+			fmt.Fprintf(w, "\n%s (%d hosts):\n", g.Title(), len(g.Hosts))
+		}
+		for _, h := range g.Hosts {
+			printHost(w, h)
+		}
+	}
+	if len(errs) > 0 {
+		fmt.Fprintf(w, "%d probes failed:\n", len(errs))
+		for _, e := range errs {
+			if e.Port == 0 {
+				fmt.Fprintf(w, "  %s: %v\n", e.IP, e.Err)
+			} else {
+				fmt.Fprintf(w, "  %s port %d: %v\n", e.IP, e.Port, e.Err)
+			}
+		}
+	}
+}
+
+// printHost writes the summary lines of one host.
+func printHost(w io.Writer, h *HostResult) {
+	if h.quarantined() {
+		fmt.Fprintf(w, "Host %s looks like a honeypot or tarpit (%s); its %d open ports are quarantined\n",
+			h.label(), strings.Join(h.Honeypot, "; "), len(h.OpenPorts()))
+		return
+	}
+	if len(h.Ports) > 0 {
+		format := "Host " + h.label() + " has %d %s ports: %v\n"
+		for _, state := range listedStates {
+			if ports := h.PortsIn(state); len(ports) > 0 {
+				fmt.Fprintf(w, format, len(ports), state, formatPorts(ports))
+				format = "  %d %s ports: %v\n"
+			}
+		}
+		for _, p := range h.Ports {
+			for _, v := range p.VHosts {
+				fmt.Fprintf(w, "  port %d virtual host %s: %s\n", p.Port, v.Name, v.Detail)
+			}
+			for _, f := range p.Notes {
+				fmt.Fprintf(w, "  port %d: %s\n", p.Port, f)
+			}
+		}
+	} else {
+		fmt.Fprintf(w, "Host %s is up but has no open ports in the specified range\n", h.label())
+	}
+}
+
+// hostGroup is the hosts of one reverse-DNS domain, in address order.
+type hostGroup struct {
+	Domain string // "" for hosts without a name in a domain
+	Hosts  []*HostResult
+}
+
+// Title names the group in the text and HTML reports.
+func (g hostGroup) Title() string {
+	if g.Domain == "" {
+		return "Hosts without a DNS domain"
+	}
+	return "Domain " + g.Domain
+}
+
+// groupByDomain groups hosts by the domain of their hostname, the name
+// minus its first label, so that e.g. all of *.corp.example.com are
+// listed together. Groups are sorted by domain, with the hosts without
+// one last; hosts keep their order within a group.
+func groupByDomain(hosts []*HostResult) []hostGroup {
+	var groups []hostGroup
+	index := make(map[string]int)
+	for _, h := range hosts {
+		d := h.domain()
+		i, ok := index[d]
+		if !ok {
+			i = len(groups)
+			index[d] = i
+			groups = append(groups, hostGroup{Domain: d})
+		}
+		groups[i].Hosts = append(groups[i].Hosts, h)
+	}
+	slices.SortFunc(groups, func(a, b hostGroup) int {
+		if (a.Domain == "") != (b.Domain == "") {
+			return cmp.Compare(b.Domain, a.Domain) // "" last
+		}
+		return strings.Compare(a.Domain, b.Domain)
+	})
+	return groups
+}
+
+// domain returns the DNS domain of the host's name, lower-cased, or "" if
+// it has no name or a single-label one.
+func (h *HostResult) domain() string {
+	name := strings.ToLower(strings.TrimSuffix(h.Hostname, "."))
+	if _, err := netip.ParseAddr(name); err != nil {
+		if _, d, ok := strings.Cut(name, "."); ok {
+			return d
+		}
+	}
+	return ""
+}
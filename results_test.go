@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGroupByDomain(t *testing.T) {
+	hosts := []*HostResult{
+		{IP: "10.0.0.1", Hostname: "dc1.corp.example.com"},
+		{IP: "10.0.0.2", Hostname: "printer"},
+		{IP: "10.0.0.3", Hostname: "web1.DMZ.example.com."},
+		{IP: "10.0.0.4", Hostname: "FS1.corp.example.com"},
+		{IP: "10.0.0.5"},
+		{IP: "10.0.0.6", Hostname: "10.0.0.6"},
+	}
+	want := []struct {
+		domain string
+		ips    []string
+	}{
+		{"corp.example.com", []string{"10.0.0.1", "10.0.0.4"}},
+		{"dmz.example.com", []string{"10.0.0.3"}},
+		{"", []string{"10.0.0.2", "10.0.0.5", "10.0.0.6"}},
+	}
+	groups := groupByDomain(hosts)
+	if len(groups) != len(want) {
+		t.Fatalf("got %d groups, want %d", len(groups), len(want))
+	}
+	for i, w := range want {
+		var ips []string
+		for _, h := range groups[i].Hosts {
+			ips = append(ips, h.IP)
+		}
+		if groups[i].Domain != w.domain || strings.Join(ips, " ") != strings.Join(w.ips, " ") {
+			t.Errorf("group %d = %q %v, want %q %v", i, groups[i].Domain, ips, w.domain, w.ips)
+		}
+	}
+}
+
+func TestSummaryGroupsByDomain(t *testing.T) {
+	hosts := []*HostResult{
+		{IP: "10.0.0.1", Hostname: "dc1.corp.example.com"},
+		{IP: "10.0.0.2", Hostname: "web1.dmz.example.com"},
+	}
+	var b strings.Builder
+	printSummary(&b, hosts, nil)
+	corp := strings.Index(b.String(), "Domain corp.example.com (1 hosts):")
+	dmz := strings.Index(b.String(), "Domain dmz.example.com (1 hosts):")
+	if corp < 0 || dmz < corp {
+		t.Errorf("summary lacks the domain headings in order:\n%s", b.String())
+	}
+
+	b.Reset()
+	printSummary(&b, hosts[:1], nil)
+	if strings.Contains(b.String(), "Domain ") {
+		t.Errorf("summary of a single domain has a heading:\n%s", b.String())
+	}
+
+	b.Reset()
+	if err := (htmlWriter{}).Write(&b, &Scan{Hosts: hosts}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), `<th colspan="3">Domain dmz.example.com (1 hosts)</th>`) {
+		t.Errorf("HTML report lacks the domain headings:\n%s", b.String())
+	}
+}
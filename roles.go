@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Host roles, in the order the summary lists them.
+var roles = []string{"server", "workstation", "printer", "network device", "honeypot", "unknown"}
+
+// roleRules are checked in order; the first rule with an open port wins.
+// Printer and network ports come first since such devices often also run
+// a web interface that would otherwise make them look like servers.
+var roleRules = []struct {
+	role  string
+	ports []int
+}{
+	{"printer", []int{9100, 515, 631}},
+	{"network device", []int{23, 179, 2000, 8291}},
+	// Directory, mail, database and web services.
+	{"server", []int{25, 53, 88, 110, 143, 389, 636, 993, 995, 1433, 1521, 3306, 5432, 6379, 27017, 80, 443, 8080, 8443}},
+	// File sharing, remote desktop and remote assistance ports of end user
+	// machines.
+	{"workstation", []int{135, 139, 445, 3389, 5900, 5357, 62078}},
+}
+
+// inferRole guesses what kind of machine h is from its open ports, or
+// those a stealth scan could not rule out. It is a heuristic for a quick
+// overview, not a fingerprint.
+func inferRole(h *HostResult) string {
+	if h.quarantined() {
+		return "honeypot"
+	}
+	open := make(map[int]bool)
+	for _, port := range h.PortsIn("open", "open|filtered") {
+		open[port] = true
+	}
+	for _, rule := range roleRules {
+		for _, port := range rule.ports {
+			if open[port] {
+				return rule.role
+			}
+		}
+	}
+	// SSH without anything more telling is typical of a headless server.
+	if open[22] {
+		return "server"
+	}
+	return "unknown"
+}
+
+// printRoles writes a one-line count of hosts per role, e.g.
+// "Roles: 3 servers, 12 workstations".
+func printRoles(w io.Writer, hosts []*HostResult) {
+	if len(hosts) == 0 {
+		return
+	}
+	counts := make(map[string]int)
+	for _, h := range hosts {
+		counts[h.Role]++
+	}
+	var parts []string
+	for _, role := range roles {
+		switch n := counts[role]; {
+		case n == 1 || n > 1 && role == "unknown":
+			parts = append(parts, fmt.Sprintf("%d %s", n, role))
+		case n > 1:
+			parts = append(parts, fmt.Sprintf("%d %ss", n, role))
+		}
+	}
+	fmt.Fprintf(w, "Roles: %s\n", strings.Join(parts, ", "))
+}
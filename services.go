@@ -0,0 +1,92 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed services.txt
+var servicesTable string
+
+// serviceEntry is one line of the embedded services table.
+type serviceEntry struct {
+	Name string
+	Port int
+}
+
+// services lists the embedded table in frequency order, and serviceNames
+// maps each port to its name.
+var services, serviceNames = loadServices(servicesTable)
+
+// serviceAliases are the everyday names for services the table lists
+// under their IANA or nmap names.
+var serviceAliases = map[string]int{
+	"rdp":      3389,
+	"smb":      445,
+	"dns":      53,
+	"mssql":    1433,
+	"postgres": 5432,
+	"kerberos": 88,
+	"netbios":  139,
+}
+
+func loadServices(table string) ([]serviceEntry, map[int]string) {
+	var entries []serviceEntry
+	names := make(map[int]string)
+	for _, line := range strings.Split(table, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		port, err := strconv.Atoi(fields[1])
+		if err != nil {
+			panic(fmt.Sprintf("services.txt: bad port in %q", line))
+		}
+		entries = append(entries, serviceEntry{Name: fields[0], Port: port})
+		if _, ok := names[port]; !ok {
+			names[port] = fields[0]
+		}
+	}
+	return entries, names
+}
+
+// topPorts returns the n most frequently open ports, sorted by number.
+func topPorts(n int) ([]int, error) {
+	if n < 1 || n > len(services) {
+		return nil, fmt.Errorf("-top-ports must be between 1 and %d", len(services))
+	}
+	ports := make([]int, n)
+	for i, s := range services[:n] {
+		ports[i] = s.Port
+	}
+	sort.Ints(ports)
+	return ports, nil
+}
+
+// servicePort returns the port of a named service, matching the table's
+// names and serviceAliases case-insensitively. A name listed for several
+// ports resolves to the most frequently open one.
+func servicePort(name string) (int, bool) {
+	name = strings.ToLower(name)
+	if port, ok := serviceAliases[name]; ok {
+		return port, true
+	}
+	for _, s := range services {
+		if strings.ToLower(s.Name) == name && s.Name != "unknown" {
+			return s.Port, true
+		}
+	}
+	return 0, false
+}
+
+// serviceName returns the usual service on port, or "" if it is not in the
+// table. "unknown" entries only exist to rank the port.
+func serviceName(port int) string {
+	if name := serviceNames[port]; name != "unknown" {
+		return name
+	}
+	return ""
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// smtpPorts are submission and relay ports the SMTP audit looks at. Port
+// 465 speaks TLS from the start, the others upgrade with STARTTLS.
+var smtpPorts = map[int]bool{25: true, 465: true, 587: true}
+
+// smtpDeadline bounds a whole SMTP conversation; servers commonly delay
+// their greeting by several seconds to deter spammers.
+const smtpDeadline = 15 * time.Second
+
+// smtpPhase checks the open SMTP ports of every live host for STARTTLS
+// support and certificate problems and, only if RelayTest is set, whether
+// they relay mail between outside domains. The relay test stops before
+// DATA, so nothing is ever delivered.
+type smtpPhase struct{}
+
+func (smtpPhase) Name() string { return "SMTP audit" }
+
+func (smtpPhase) Run(s *Scan) error {
+	isSMTP := func(_ *HostResult, p *PortResult) bool { return smtpPorts[p.Port] }
+	s.probePorts(smtpPhase{}, isSMTP, func(h *HostResult, p *PortResult) error {
+		banner, findings, err := auditSMTP(h.IP, p.Port, s.Timeout, s.RelayTest)
+		if err != nil {
+			findings = append(findings, "SMTP audit incomplete: "+err.Error())
+		}
+		p.Banner = banner
+		p.Notes = append(p.Notes, findings...)
+		return nil
+	})
+	return nil
+}
+
+// auditSMTP talks to the SMTP server at ip:port and returns its greeting
+// and what it found.
+func auditSMTP(ip string, port int, timeout time.Duration, relayTest bool) (string, []string, error) {
+	probeRate.wait()
+	addr := net.JoinHostPort(ip, strconv.Itoa(port))
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return "", nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(smtpDeadline))
+
+	var findings []string
+	tlsConfig := &tls.Config{InsecureSkipVerify: true}
+	if port == 465 {
+		tc := tls.Client(conn, tlsConfig)
+		if err := tc.Handshake(); err != nil {
+			return "", nil, fmt.Errorf("TLS handshake: %w", err)
+		}
+		findings = append(findings, certFindings(tc.ConnectionState())...)
+		conn = tc
+	}
+
+	text := textproto.NewConn(conn)
+	_, banner, err := text.ReadResponse(220)
+	if err != nil {
+		return "", nil, fmt.Errorf("greeting: %w", err)
+	}
+	banner, _, _ = strings.Cut(banner, "\n")
+	caps, err := textCmd(text, 250, "EHLO scanner.invalid")
+	if err != nil {
+		return banner, findings, err
+	}
+
+	if port != 465 {
+		if !strings.Contains(strings.ToUpper(caps), "STARTTLS") {
+			findings = append(findings, "STARTTLS not offered: mail to this server travels in cleartext")
+		} else if _, err := textCmd(text, 220, "STARTTLS"); err != nil {
+			return banner, findings, err
+		} else {
+			tc := tls.Client(conn, tlsConfig)
+			if err := tc.Handshake(); err != nil {
+				findings = append(findings, "STARTTLS offered but the TLS handshake fails: "+err.Error())
+				return banner, findings, nil
+			}
+			findings = append(findings, certFindings(tc.ConnectionState())...)
+			text = textproto.NewConn(tc)
+			if _, err := textCmd(text, 250, "EHLO scanner.invalid"); err != nil {
+				return banner, findings, err
+			}
+		}
+	}
+
+	if relayTest {
+		finding, err := relayCheck(text)
+		if err != nil {
+			return banner, findings, err
+		}
+		findings = append(findings, finding)
+	}
+	textCmd(text, 221, "QUIT")
+	return banner, findings, nil
+}
+
+// relayCheck asks the server to take mail from one outside domain to
+// another, then resets the transaction before any message is sent.
+func relayCheck(text *textproto.Conn) (string, error) {
+	if _, err := textCmd(text, 250, "MAIL FROM:<relay-test@example.com>"); err != nil {
+		return "", err
+	}
+	_, err := textCmd(text, 25, "RCPT TO:<relay-test@example.net>")
+	textCmd(text, 250, "RSET")
+	if err != nil {
+		if tpErr, ok := err.(*textproto.Error); ok {
+			return fmt.Sprintf("relaying to outside domains refused (%d)", tpErr.Code), nil
+		}
+		return "", err
+	}
+	return "accepts mail between outside domains: possible open relay", nil
+}
+
+// textCmd sends a command over a line-based protocol with numbered
+// replies, SMTP or FTP, and reads the reply, which must have a code
+// starting with expect.
+func textCmd(text *textproto.Conn, expect int, format string, args ...any) (string, error) {
+	id, err := text.Cmd(format, args...)
+	if err != nil {
+		return "", err
+	}
+	text.StartResponse(id)
+	defer text.EndResponse(id)
+	_, msg, err := text.ReadResponse(expect)
+	return msg, err
+}
+
+// certFindings describes the server certificate of a TLS connection and
+// flags common problems with it.
+func certFindings(cs tls.ConnectionState) []string {
+	if len(cs.PeerCertificates) == 0 {
+		return []string{"TLS without a server certificate"}
+	}
+	cert := cs.PeerCertificates[0]
+	findings := []string{fmt.Sprintf("%s certificate %s, issued by %s, valid until %s",
+		tls.VersionName(cs.Version), cert.Subject, cert.Issuer, cert.NotAfter.Format(time.DateOnly))}
+	if time.Now().After(cert.NotAfter) {
+		findings = append(findings, "certificate expired")
+	}
+	if cert.CheckSignatureFrom(cert) == nil || cert.Issuer.String() == cert.Subject.String() {
+		findings = append(findings, "certificate is self-signed")
+	}
+	if cs.Version < tls.VersionTLS12 {
+		findings = append(findings, "TLS version below 1.2")
+	}
+	return findings
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var ssdpGroup = &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900}
+
+// ssdpSearch asks every UPnP device to announce itself.
+const ssdpSearch = "M-SEARCH * HTTP/1.1\r\n" +
+	"HOST: 239.255.255.250:1900\r\n" +
+	"MAN: \"ssdp:discover\"\r\n" +
+	"MX: 1\r\n" +
+	"ST: ssdp:all\r\n\r\n"
+
+// ssdpSweep multicasts an SSDP M-SEARCH and returns the device description
+// URL each responder announced, keyed by responder address.
+func ssdpSweep(wait time.Duration) (map[string]string, error) {
+	probeRate.wait()
+	c, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	if _, err := c.WriteTo([]byte(ssdpSearch), ssdpGroup); err != nil {
+		return nil, fmt.Errorf("sending SSDP search: %w", err)
+	}
+	probeBytes.sent.Add(int64(len(ssdpSearch)))
+
+	locations := make(map[string]string)
+	c.SetReadDeadline(time.Now().Add(wait))
+	buf := make([]byte, 2048)
+	for {
+		n, peer, err := c.ReadFromUDP(buf)
+		if err != nil {
+			return locations, nil
+		}
+		probeBytes.received.Add(int64(n))
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+		ip := peer.IP.String()
+		if loc := resp.Header.Get("Location"); loc != "" && locations[ip] == "" {
+			locations[ip] = loc
+		}
+	}
+}
+
+// upnpDevice is the part of a UPnP device description that is reported.
+type upnpDevice struct {
+	FriendlyName string `xml:"device>friendlyName"`
+	Manufacturer string `xml:"device>manufacturer"`
+	ModelName    string `xml:"device>modelName"`
+}
+
+// String formats the device as "friendly name (manufacturer model)".
+func (d upnpDevice) String() string {
+	model := strings.TrimSpace(d.Manufacturer + " " + d.ModelName)
+	switch {
+	case d.FriendlyName == "":
+		return model
+	case model == "":
+		return d.FriendlyName
+	}
+	return fmt.Sprintf("%s (%s)", d.FriendlyName, model)
+}
+
+// fetchUPnPDevice downloads and parses the description at location.
+func fetchUPnPDevice(location string, timeout time.Duration) (upnpDevice, error) {
+	probeRate.wait()
+	var d upnpDevice
+	client := http.Client{Timeout: timeout}
+	resp, err := client.Get(location)
+	if err != nil {
+		return d, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return d, fmt.Errorf("fetching %s: %s", location, resp.Status)
+	}
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&d); err != nil {
+		return d, fmt.Errorf("parsing %s: %w", location, err)
+	}
+	return d, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// ScanStats summarises a run. It is filled in by the aggregators of the
+// discovery and port scan phases and by Scan.Run.
+type ScanStats struct {
+	TargetsExpanded int            `json:"targets_expanded" xml:"targets_expanded"`
+	HostsProbed     int            `json:"hosts_probed" xml:"hosts_probed"`
+	HostsUp         int            `json:"hosts_up" xml:"hosts_up"`
+	HostsDown       int            `json:"hosts_down" xml:"hosts_down"`
+	PortsScanned    int            `json:"ports_scanned" xml:"ports_scanned"`
+	PortsOpen       int            `json:"ports_open" xml:"ports_open"`
+	PortsClosed     int            `json:"ports_closed" xml:"ports_closed"`
+	PortsFiltered   int            `json:"ports_filtered" xml:"ports_filtered"`
+	Errors          map[string]int `json:"errors_by_class" xml:"-"`
+	Phases          []PhaseTiming  `json:"phases" xml:"phase"`
+	// Bytes written and read by probes themselves. TCP handshakes are
+	// performed by the kernel and are not included.
+	BytesSent     int64 `json:"bytes_sent" xml:"bytes_sent"`
+	BytesReceived int64 `json:"bytes_received" xml:"bytes_received"`
+	// Unanswered FIN, NULL and XMAS probes; see stealthProbe.
+	PortsOpenFiltered int `json:"ports_open_filtered,omitempty" xml:"ports_open_filtered,omitempty"`
+	// ACK probes answered with a RST; see ackScanPort.
+	PortsUnfiltered int `json:"ports_unfiltered,omitempty" xml:"ports_unfiltered,omitempty"`
+}
+
+// PhaseTiming is how long one phase of the scan took.
+type PhaseTiming struct {
+	Name     string        `json:"name" xml:"name,attr"`
+	Duration time.Duration `json:"duration_ns" xml:"duration_ns,attr"`
+}
+
+// probeBytes counts probe payload traffic across all workers.
+var probeBytes struct {
+	sent, received atomic.Int64
+}
+
+// errorClass groups probe errors for the statistics, by errno where there
+// is one.
+func errorClass(err error) string {
+	var errno syscall.Errno
+	switch {
+	case errors.As(err, &errno):
+		return errno.Error()
+	case strings.HasPrefix(err.Error(), "probe panicked"):
+		return "panic"
+	}
+	return "other"
+}
+
+// printStats writes the statistics as key=value lines so they can be
+// picked out of the console output by scripts.
+func printStats(w io.Writer, st *ScanStats) {
+	fmt.Fprintf(w, "\nScan Statistics:\n")
+	fmt.Fprintf(w, "targets_expanded=%d hosts_probed=%d hosts_up=%d hosts_down=%d\n",
+		st.TargetsExpanded, st.HostsProbed, st.HostsUp, st.HostsDown)
+	fmt.Fprintf(w, "ports_scanned=%d ports_open=%d ports_closed=%d ports_filtered=%d",
+		st.PortsScanned, st.PortsOpen, st.PortsClosed, st.PortsFiltered)
+	if st.PortsOpenFiltered > 0 {
+		fmt.Fprintf(w, " ports_open_filtered=%d", st.PortsOpenFiltered)
+	}
+	if st.PortsUnfiltered > 0 {
+		fmt.Fprintf(w, " ports_unfiltered=%d", st.PortsUnfiltered)
+	}
+	fmt.Fprintln(w)
+
+	total := 0
+	var classes []string
+	for class, n := range st.Errors {
+		total += n
+		classes = append(classes, fmt.Sprintf("%q=%d", class, n))
+	}
+	sort.Strings(classes)
+	fmt.Fprintln(w, strings.Join(append([]string{fmt.Sprintf("errors=%d", total)}, classes...), " "))
+
+	var phases []string
+	for _, p := range st.Phases {
+		phases = append(phases, fmt.Sprintf("%q=%v", p.Name, p.Duration))
+	}
+	fmt.Fprintf(w, "phases %s\n", strings.Join(phases, " "))
+	fmt.Fprintf(w, "bytes_sent=%d bytes_received=%d\n", st.BytesSent, st.BytesReceived)
+}
+
+// add sums the counters of o into st, for results merged from several
+// scans. Phase timings are not carried over.
+func (st *ScanStats) add(o *ScanStats) {
+	st.TargetsExpanded += o.TargetsExpanded
+	st.HostsProbed += o.HostsProbed
+	st.HostsUp += o.HostsUp
+	st.HostsDown += o.HostsDown
+	st.PortsScanned += o.PortsScanned
+	st.PortsOpen += o.PortsOpen
+	st.PortsClosed += o.PortsClosed
+	st.PortsFiltered += o.PortsFiltered
+	st.PortsOpenFiltered += o.PortsOpenFiltered
+	st.PortsUnfiltered += o.PortsUnfiltered
+	st.BytesSent += o.BytesSent
+	st.BytesReceived += o.BytesReceived
+	for class, n := range o.Errors {
+		if st.Errors == nil {
+			st.Errors = make(map[string]int)
+		}
+		st.Errors[class] += n
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// resolveTimeout bounds each forward lookup of a target hostname and each
+// reverse lookup of a live host.
+const resolveTimeout = 5 * time.Second
+
+// Target is one address to scan. Name is the hostname the address was
+// resolved from, when it came from one.
+type Target struct {
+	IP   string
+	Name string
+}
+
+// TargetProvider produces scan targets one at a time. Next returns io.EOF
+// once the provider is exhausted; any other error aborts target expansion.
+// Providers are consumed lazily, so one describing a huge space costs
+// nothing until it is drained.
+type TargetProvider interface {
+	Next() (Target, error)
+}
+
+// rangeProvider yields every address of an inclusive range.
+type rangeProvider struct {
+	next, end netip.Addr
+	done      bool
+}
+
+func newRangeProvider(startIP, endIP string) (*rangeProvider, error) {
+	start, end, err := parseRange(startIP, endIP)
+	if err != nil {
+		return nil, err
+	}
+	return &rangeProvider{next: start, end: end}, nil
+}
+
+func (p *rangeProvider) Next() (Target, error) {
+	if p.done {
+		return Target{}, io.EOF
+	}
+	ip := p.next
+	// Stop on reaching end explicitly rather than comparing after Next, so
+	// ranges ending at the top of the address space terminate.
+	if ip == p.end {
+		p.done = true
+	} else {
+		p.next = ip.Next()
+	}
+	return Target{IP: ip.String()}, nil
+}
+
+// newCIDRProvider yields the host addresses of a prefix. For IPv4 the
+// network and broadcast addresses are skipped, except for /31 and /32 where
+// every address is a host (RFC 3021). IPv6 has no broadcast address, so
+// every address of an IPv6 prefix is yielded.
+func newCIDRProvider(cidr string) (*rangeProvider, error) {
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR %q", cidr)
+	}
+	prefix = prefix.Masked()
+
+	start, end := prefix.Addr(), lastAddr(prefix)
+	if start.Is4() && prefix.Bits() <= 30 {
+		start, end = start.Next(), end.Prev()
+	}
+	return &rangeProvider{next: start, end: end}, nil
+}
+
+// parseTarget turns a target specification, a CIDR prefix, an address
+// range such as 10.0.0.1-10.0.0.50, or anything parseHost accepts, into a
+// provider.
+func parseTarget(spec string) (TargetProvider, error) {
+	if strings.Contains(spec, "/") {
+		return newCIDRProvider(spec)
+	}
+	// Hostnames may contain dashes too, so only treat this as a range when
+	// both sides are addresses.
+	if isRangeSpec(spec) {
+		start, end, _ := strings.Cut(spec, "-")
+		return newRangeProvider(start, end)
+	}
+	return parseHost(spec)
+}
+
+// parseHost turns a single address or hostname into a provider. A hostname
+// yields every address it resolves to.
+func parseHost(spec string) (TargetProvider, error) {
+	if net.ParseIP(spec) != nil {
+		return newRangeProvider(spec, spec)
+	}
+	return newDNSProvider(spec)
+}
+
+// listProvider yields a fixed list of targets.
+type listProvider []Target
+
+func (l *listProvider) Next() (Target, error) {
+	if len(*l) == 0 {
+		return Target{}, io.EOF
+	}
+	t := (*l)[0]
+	*l = (*l)[1:]
+	return t, nil
+}
+
+// newDNSProvider resolves name and yields each of its IPv4 and IPv6
+// addresses, tagged with the name so results can be reported per resolved
+// address.
+func newDNSProvider(name string) (*listProvider, error) {
+	ips, err := lookupIP(name)
+	if err != nil {
+		return nil, err
+	}
+	var l listProvider
+	for _, ip := range ips {
+		l = append(l, Target{IP: ip.String(), Name: name})
+	}
+	if len(l) > 1 {
+		fmt.Fprintf(console, "%s resolves to %d addresses, scanning all of them\n", name, len(l))
+	}
+	return &l, nil
+}
+
+// resolveBound returns host unchanged if it is an address, or the address
+// it resolves to, for use as one end of a range. IPv4 addresses are
+// preferred so a dual-stack name pairs with an IPv4 other end.
+func resolveBound(host string) (string, error) {
+	if net.ParseIP(host) != nil {
+		return host, nil
+	}
+	ips, err := lookupIP(host)
+	if err != nil {
+		return "", err
+	}
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			return ip.String(), nil
+		}
+	}
+	return ips[0].String(), nil
+}
+
+// lookupIP returns the addresses name resolves to.
+func lookupIP(name string) ([]net.IP, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", name)
+	if err != nil {
+		return nil, fmt.Errorf("resolving %s: %w", name, err)
+	}
+	return ips, nil
+}
+
+// lookupAddr returns the first name a PTR lookup of ip yields, without
+// the trailing dot, or "" if there is none.
+func lookupAddr(ip string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	names, err := net.DefaultResolver.LookupAddr(ctx, ip)
+	if err != nil || len(names) == 0 {
+		return ""
+	}
+	return strings.TrimSuffix(names[0], ".")
+}
+
+// listFileProvider reads one target specification per line, in any form
+// parseTarget accepts. Blank lines and lines starting with # are skipped.
+// Lines are parsed as they are reached, so hostnames are resolved only when
+// the scan gets to them.
+type listFileProvider struct {
+	name    string
+	scanner *bufio.Scanner
+	line    int
+	current TargetProvider
+}
+
+func newListFileProvider(r io.Reader, name string) *listFileProvider {
+	return &listFileProvider{name: name, scanner: bufio.NewScanner(r)}
+}
+
+func (p *listFileProvider) Next() (Target, error) {
+	for {
+		if p.current != nil {
+			t, err := p.current.Next()
+			if err != io.EOF {
+				return t, err
+			}
+			p.current = nil
+		}
+
+		if !p.scanner.Scan() {
+			if err := p.scanner.Err(); err != nil {
+				return Target{}, fmt.Errorf("%s: %w", p.name, err)
+			}
+			return Target{}, io.EOF
+		}
+		p.line++
+		spec := strings.TrimSpace(p.scanner.Text())
+		if spec == "" || strings.HasPrefix(spec, "#") {
+			continue
+		}
+		current, err := parseTarget(spec)
+		if err != nil {
+			return Target{}, fmt.Errorf("%s:%d: %w", p.name, p.line, err)
+		}
+		p.current = current
+	}
+}
+
+// exclusionList is a set of addresses and prefixes to leave out of a scan.
+type exclusionList []*net.IPNet
+
+// add parses a comma-separated list of addresses and CIDR blocks.
+func (e *exclusionList) add(list string) error {
+	for _, spec := range strings.Split(list, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		cidr := spec
+		if !strings.Contains(cidr, "/") {
+			if strings.Contains(cidr, ":") {
+				cidr += "/128"
+			} else {
+				cidr += "/32"
+			}
+		}
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid exclusion %q", spec)
+		}
+		*e = append(*e, ipnet)
+	}
+	return nil
+}
+
+// addFile reads exclusions from r, one or more per line. Anything after a
+// # is a comment.
+func (e *exclusionList) addFile(r io.Reader, name string) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		spec, _, _ := strings.Cut(scanner.Text(), "#")
+		if err := e.add(spec); err != nil {
+			return fmt.Errorf("%s:%d: %w", name, line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+func (e exclusionList) contains(ip string) bool {
+	addr := net.ParseIP(ip)
+	for _, ipnet := range e {
+		if ipnet.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// multiProvider drains several providers in order.
+type multiProvider []TargetProvider
+
+func (m *multiProvider) Next() (Target, error) {
+	for len(*m) > 0 {
+		t, err := (*m)[0].Next()
+		if err != io.EOF {
+			return t, err
+		}
+		*m = (*m)[1:]
+	}
+	return Target{}, io.EOF
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"testing"
+)
+
+// drain returns the count and the first and last addresses a provider
+// yields, failing the test on any error but io.EOF.
+func drain(t *testing.T, p TargetProvider) (n int, first, last string) {
+	t.Helper()
+	for {
+		target, err := p.Next()
+		if errors.Is(err, io.EOF) {
+			return n, first, last
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		if n == 0 {
+			first = target.IP
+		}
+		last = target.IP
+		n++
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		start, end string
+		wantErr    bool
+	}{
+		{"192.168.1.1", "192.168.1.255", false},
+		{"0.0.0.0", "0.0.0.0", false},
+		{"255.255.255.255", "255.255.255.255", false},
+		{"10.0.0.0", "10.5.0.0", false},
+		{"::ffff:10.0.0.1", "10.0.0.2", false}, // mapped addresses are unmapped
+		{"2001:db8::1", "2001:db8::ff", false},
+		{"10.0.0.2", "10.0.0.1", true},
+		{"10.0.0.1", "2001:db8::1", true},
+		{"10.0.0.256", "10.0.1.1", true},
+		{"10.0.0.1", "", true},
+		{"10.0.0.0/24", "10.0.1.0", true},
+	}
+	for _, tt := range tests {
+		_, _, err := parseRange(tt.start, tt.end)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseRange(%q, %q) error = %v, want error %v", tt.start, tt.end, err, tt.wantErr)
+		}
+	}
+}
+
+func TestRangeProvider(t *testing.T) {
+	tests := []struct {
+		start, end  string
+		n           int
+		first, last string
+	}{
+		{"192.168.1.250", "192.168.1.255", 6, "192.168.1.250", "192.168.1.255"},
+		{"192.168.1.255", "192.168.2.1", 3, "192.168.1.255", "192.168.2.1"},
+		{"10.0.0.1", "10.0.0.1", 1, "10.0.0.1", "10.0.0.1"},
+		{"0.0.0.0", "0.0.0.0", 1, "0.0.0.0", "0.0.0.0"},
+		{"0.0.0.0", "0.0.0.3", 4, "0.0.0.0", "0.0.0.3"},
+		{"255.255.255.255", "255.255.255.255", 1, "255.255.255.255", "255.255.255.255"},
+		{"255.255.255.250", "255.255.255.255", 6, "255.255.255.250", "255.255.255.255"},
+		// Across four /16s: two addresses of 10.0, all of 10.1 and 10.2,
+		// two of 10.3.
+		{"10.0.255.254", "10.3.0.1", 2 + 2*65536 + 2, "10.0.255.254", "10.3.0.1"},
+		{"172.16.0.0", "172.31.255.255", 16 * 65536, "172.16.0.0", "172.31.255.255"},
+		{"2001:db8::fffe", "2001:db8::1:1", 4, "2001:db8::fffe", "2001:db8::1:1"},
+		{"ffff:ffff:ffff:ffff:ffff:ffff:ffff:fffe", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", 2,
+			"ffff:ffff:ffff:ffff:ffff:ffff:ffff:fffe", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"},
+	}
+	for _, tt := range tests {
+		p, err := newRangeProvider(tt.start, tt.end)
+		if err != nil {
+			t.Errorf("newRangeProvider(%q, %q): %v", tt.start, tt.end, err)
+			continue
+		}
+		n, first, last := drain(t, p)
+		if n != tt.n || first != tt.first || last != tt.last {
+			t.Errorf("range %s-%s yielded %d addresses %s to %s, want %d %s to %s",
+				tt.start, tt.end, n, first, last, tt.n, tt.first, tt.last)
+		}
+	}
+}
+
+func TestCIDRProvider(t *testing.T) {
+	tests := []struct {
+		cidr        string
+		n           int
+		first, last string
+	}{
+		{"192.168.1.0/24", 254, "192.168.1.1", "192.168.1.254"},
+		{"192.168.1.77/24", 254, "192.168.1.1", "192.168.1.254"}, // host bits are masked
+		{"10.0.0.0/30", 2, "10.0.0.1", "10.0.0.2"},
+		{"10.0.0.0/31", 2, "10.0.0.0", "10.0.0.1"},
+		{"10.0.0.5/32", 1, "10.0.0.5", "10.0.0.5"},
+		{"10.0.0.0/14", 4*65536 - 2, "10.0.0.1", "10.3.255.254"},
+		{"0.0.0.0/32", 1, "0.0.0.0", "0.0.0.0"},
+		{"0.0.0.0/30", 2, "0.0.0.1", "0.0.0.2"},
+		{"255.255.255.255/32", 1, "255.255.255.255", "255.255.255.255"},
+		{"255.255.255.254/31", 2, "255.255.255.254", "255.255.255.255"},
+		{"255.255.255.0/24", 254, "255.255.255.1", "255.255.255.254"},
+		{"2001:db8::/126", 4, "2001:db8::", "2001:db8::3"},
+	}
+	for _, tt := range tests {
+		p, err := newCIDRProvider(tt.cidr)
+		if err != nil {
+			t.Errorf("newCIDRProvider(%q): %v", tt.cidr, err)
+			continue
+		}
+		n, first, last := drain(t, p)
+		if n != tt.n || first != tt.first || last != tt.last {
+			t.Errorf("%s yielded %d addresses %s to %s, want %d %s to %s",
+				tt.cidr, n, first, last, tt.n, tt.first, tt.last)
+		}
+	}
+
+	for _, cidr := range []string{"10.0.0.0/33", "10.0.0.0", "10.0.0/24", ""} {
+		if _, err := newCIDRProvider(cidr); err == nil {
+			t.Errorf("newCIDRProvider(%q) succeeded, want an error", cidr)
+		}
+	}
+}
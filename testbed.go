@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runTestbed implements the testbed subcommand: it listens on a set of
+// local TCP and UDP ports, optionally answering with fixed banners, so scan
+// behavior can be checked end-to-end without external hosts.
+func runTestbed(args []string) error {
+	fs := flag.NewFlagSet("testbed", flag.ExitOnError)
+	addr := fs.String("addr", "127.0.0.1", "Address to listen on")
+	tcpPorts := fs.String("tcp", "8080", "TCP ports to open, e.g. 22,80,8000-8010")
+	udpPorts := fs.String("udp", "", "UDP ports to open, in the same form as -tcp")
+	banners := make(map[int]string)
+	fs.Func("banner", "Banner to send as `port=text`, repeatable; applies to TCP and UDP", func(v string) error {
+		port, text, ok := strings.Cut(v, "=")
+		if !ok {
+			return fmt.Errorf("want port=text, got %q", v)
+		}
+		n, err := strconv.Atoi(port)
+		if err != nil {
+			return fmt.Errorf("invalid port %q", port)
+		}
+		banners[n] = text
+		return nil
+	})
+	fs.Parse(args)
+
+	var tcp, udp []int
+	var err error
+	if *tcpPorts != "" {
+		if tcp, err = parsePorts(*tcpPorts); err != nil {
+			return fmt.Errorf("-tcp: %w", err)
+		}
+	}
+	if *udpPorts != "" {
+		if udp, err = parsePorts(*udpPorts); err != nil {
+			return fmt.Errorf("-udp: %w", err)
+		}
+	}
+
+	for _, port := range tcp {
+		ln, err := net.Listen("tcp", net.JoinHostPort(*addr, strconv.Itoa(port)))
+		if err != nil {
+			return err
+		}
+		defer ln.Close()
+		fmt.Fprintf(console, "Listening on tcp %s\n", ln.Addr())
+		go serveTCP(ln, banners[port])
+	}
+	for _, port := range udp {
+		pc, err := net.ListenPacket("udp", net.JoinHostPort(*addr, strconv.Itoa(port)))
+		if err != nil {
+			return err
+		}
+		defer pc.Close()
+		fmt.Fprintf(console, "Listening on udp %s\n", pc.LocalAddr())
+		go serveUDP(pc, banners[port])
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	<-sig
+	return nil
+}
+
+// serveTCP accepts connections, sends banner if set, and closes them.
+func serveTCP(ln net.Listener, banner string) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			if banner != "" {
+				conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+				fmt.Fprintf(conn, "%s\r\n", banner)
+			}
+		}()
+	}
+}
+
+// serveUDP answers every datagram with banner, or echoes it back when no
+// banner is set.
+func serveUDP(pc net.PacketConn, banner string) {
+	buf := make([]byte, 65535)
+	for {
+		n, from, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		reply := buf[:n]
+		if banner != "" {
+			reply = []byte(banner + "\r\n")
+		}
+		pc.WriteTo(reply, from)
+	}
+}
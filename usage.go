@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// usageError reports a command line mistake together with the invocation
+// the user most likely meant.
+type usageError struct {
+	problem string
+	hint    string
+}
+
+func (e *usageError) Error() string { return e.problem }
+
+var modes = []string{"range", "specific", "gateway", "internet", "compare", "reach"}
+
+// checkUsage catches flag combinations that would otherwise fail with a
+// generic message or quietly scan something other than what was asked.
+// set holds the names of the flags given on the command line.
+func checkUsage(mode, scanType, ip, start, end string, set map[string]bool) error {
+	if !contains(modes, mode) {
+		e := &usageError{problem: fmt.Sprintf("unknown mode %q (valid modes: %s)", mode, strings.Join(modes, ", "))}
+		if m := closest(mode, modes); m != "" {
+			e.hint = "-mode " + m
+		}
+		return e
+	}
+
+	if _, ok := scanTypes[scanType]; !ok {
+		names := make([]string, 0, len(scanTypes))
+		for name := range scanTypes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		e := &usageError{problem: fmt.Sprintf("unknown scan type %q (valid types: %s)", scanType, strings.Join(names, ", "))}
+		if t := closest(scanType, names); t != "" {
+			e.hint = "-scan " + t
+		}
+		return e
+	}
+
+	if set["ip"] && (strings.Contains(ip, "/") || isRangeSpec(ip)) {
+		return &usageError{
+			problem: fmt.Sprintf("-ip takes a single address or hostname, not the block %q", ip),
+			hint:    "-mode range -target " + ip,
+		}
+	}
+	if set["ip"] && mode != "specific" && mode != "reach" {
+		return &usageError{
+			problem: fmt.Sprintf("-ip is only used in specific and reach modes, but the mode is %s", mode),
+			hint:    "-mode specific -ip " + ip,
+		}
+	}
+	for _, name := range []string{"target", "iL", "cloud"} {
+		if set[name] && mode != "range" {
+			return &usageError{
+				problem: fmt.Sprintf("-%s is only used in range mode, but the mode is %s", name, mode),
+				hint:    "-mode range -" + name + " ...",
+			}
+		}
+	}
+	for _, name := range []string{"interval", "count", "source"} {
+		if set[name] && mode != "reach" {
+			return &usageError{
+				problem: fmt.Sprintf("-%s is only used in reach mode, but the mode is %s", name, mode),
+				hint:    "-mode reach -ip ... -ports ... -" + name + " ...",
+			}
+		}
+	}
+	if (set["a"] || set["b"]) && mode != "compare" {
+		return &usageError{
+			problem: "-a and -b are only used in compare mode",
+			hint:    "-mode compare -a ... -b ...",
+		}
+	}
+	// Compare mode only discovers and port scans the two groups, then
+	// prints the drift; there are no results to write or enrich.
+	for _, name := range []string{"o", "oA", "compress", "vhosts", "smtp-relay-test", "ad"} {
+		if set[name] && mode == "compare" {
+			return &usageError{
+				problem: fmt.Sprintf("-%s has no effect in compare mode, which only reports the open ports that differ", name),
+				hint:    "-mode range -target ... -" + name + " ...",
+			}
+		}
+	}
+
+	for _, bound := range []string{start, end} {
+		if strings.Contains(bound, "/") {
+			return &usageError{
+				problem: fmt.Sprintf("-start and -end take single addresses, not the block %q", bound),
+				hint:    "-target " + bound,
+			}
+		}
+	}
+	s, errS := netip.ParseAddr(start)
+	e, errE := netip.ParseAddr(end)
+	if errS == nil && errE == nil && s.Is4() == e.Is4() && s.Compare(e) > 0 {
+		return &usageError{
+			problem: fmt.Sprintf("start address %s is after end address %s", s, e),
+			hint:    fmt.Sprintf("-start %s -end %s", e, s),
+		}
+	}
+	return nil
+}
+
+// isRangeSpec reports whether spec is an "a-b" address range, using the
+// same rule as parseTarget.
+func isRangeSpec(spec string) bool {
+	start, end, ok := strings.Cut(spec, "-")
+	return ok && net.ParseIP(start) != nil && net.ParseIP(end) != nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// closest returns the candidate within two edits of s, or "" if none is.
+func closest(s string, candidates []string) string {
+	best, bestDist := "", 3
+	for _, c := range candidates {
+		if d := editDistance(strings.ToLower(s), c); d < bestDist {
+			best, bestDist = c, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,93 @@
+package main
+
+import "testing"
+
+func TestCheckUsage(t *testing.T) {
+	tests := []struct {
+		name        string
+		mode        string
+		scanType    string
+		ip          string
+		start, end  string
+		set         []string
+		wantProblem bool
+		wantHint    string
+	}{
+		{name: "defaults", mode: "range"},
+		{name: "specific host", mode: "specific", ip: "10.0.0.1", set: []string{"ip"}},
+		{name: "misspelled mode", mode: "rnage", wantProblem: true, wantHint: "-mode range"},
+		{name: "misspelled mode in capitals", mode: "SPECIFC", wantProblem: true, wantHint: "-mode specific"},
+		{name: "unknown mode", mode: "everything", wantProblem: true},
+		{name: "misspelled scan type", mode: "range", scanType: "sny", wantProblem: true, wantHint: "-scan syn"},
+		{name: "unknown scan type", mode: "range", scanType: "udp-flood", wantProblem: true},
+		{name: "CIDR passed to -ip", mode: "specific", ip: "10.0.0.0/24", set: []string{"ip"},
+			wantProblem: true, wantHint: "-mode range -target 10.0.0.0/24"},
+		{name: "range passed to -ip", mode: "specific", ip: "10.0.0.1-10.0.0.9", set: []string{"ip"},
+			wantProblem: true, wantHint: "-mode range -target 10.0.0.1-10.0.0.9"},
+		{name: "-ip in range mode", mode: "range", ip: "10.0.0.1", set: []string{"ip"},
+			wantProblem: true, wantHint: "-mode specific -ip 10.0.0.1"},
+		{name: "-target outside range mode", mode: "specific", set: []string{"target"},
+			wantProblem: true, wantHint: "-mode range -target ..."},
+		{name: "-count outside reach mode", mode: "range", set: []string{"count"},
+			wantProblem: true, wantHint: "-mode reach -ip ... -ports ... -count ..."},
+		{name: "-a outside compare mode", mode: "range", set: []string{"a"},
+			wantProblem: true, wantHint: "-mode compare -a ... -b ..."},
+		{name: "-o in compare mode", mode: "compare", set: []string{"a", "b", "o"},
+			wantProblem: true, wantHint: "-mode range -target ... -o ..."},
+		{name: "swapped start and end", mode: "range", start: "10.0.0.9", end: "10.0.0.1",
+			wantProblem: true, wantHint: "-start 10.0.0.1 -end 10.0.0.9"},
+		{name: "CIDR passed to -start", mode: "range", start: "10.0.0.0/24", end: "10.0.0.255",
+			wantProblem: true, wantHint: "-target 10.0.0.0/24"},
+		{name: "mixed families are left to parseRange", mode: "range", start: "10.0.0.9", end: "::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set := make(map[string]bool)
+			for _, name := range tt.set {
+				set[name] = true
+			}
+			scanType, start, end := tt.scanType, tt.start, tt.end
+			if scanType == "" {
+				scanType = "connect"
+			}
+			if start == "" {
+				start, end = "192.168.1.1", "192.168.1.255"
+			}
+			err := checkUsage(tt.mode, scanType, tt.ip, start, end, set)
+			if (err != nil) != tt.wantProblem {
+				t.Fatalf("checkUsage error = %v, want error %v", err, tt.wantProblem)
+			}
+			if err == nil {
+				return
+			}
+			ue, ok := err.(*usageError)
+			if !ok {
+				t.Fatalf("checkUsage returned %T, want *usageError", err)
+			}
+			if ue.hint != tt.wantHint {
+				t.Errorf("hint = %q, want %q", ue.hint, tt.wantHint)
+			}
+		})
+	}
+}
+
+func TestClosest(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{"range", "range"},
+		{"rang", "range"},
+		{"reange", "range"},
+		{"Compar", "compare"},
+		{"gatway", "gateway"},
+		{"rech", "reach"},
+		{"zzz", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := closest(tt.s, modes); got != tt.want {
+			t.Errorf("closest(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
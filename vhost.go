@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// VHost is a name-based virtual host found on a port: a name for which the
+// server answers differently than it does by default.
+type VHost struct {
+	Name   string `json:"name" xml:"name,attr"`
+	Detail string `json:"detail" xml:",chardata"` // what differed, e.g. the certificate subject
+}
+
+// readNames reads hostnames, one per line. Blank lines and # comments are
+// skipped.
+func readNames(r io.Reader) ([]string, error) {
+	var names []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		name, _, _ := strings.Cut(scanner.Text(), "#")
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, scanner.Err()
+}
+
+// vhostPhase tries the candidate hostnames on the open ports of every live
+// host: as TLS server names where the port speaks TLS, as HTTP Host
+// headers where it speaks plain HTTP. The candidates are those given with
+// -vhosts plus the host's own name.
+type vhostPhase struct{}
+
+func (vhostPhase) Name() string { return "vhost enumeration" }
+
+func (vhostPhase) Run(s *Scan) error {
+	if len(s.VHosts) == 0 {
+		return nil
+	}
+	s.probePorts(vhostPhase{}, anyPort, func(h *HostResult, p *PortResult) error {
+		names := s.VHosts
+		if h.Hostname != "" && !slices.Contains(names, h.Hostname) {
+			names = append(slices.Clone(names), h.Hostname)
+		}
+		var isTLS bool
+		p.VHosts, isTLS = tlsVHosts(h.IP, p.Port, names, s.Timeout)
+		if !isTLS {
+			p.VHosts = httpVHosts(h.IP, p.Port, names, s.Timeout)
+		}
+		return nil
+	})
+	return nil
+}
+
+// tlsVHosts handshakes with ip:port once without SNI and once per name,
+// and returns the names for which the server presents a different
+// certificate, and whether the port speaks TLS at all.
+func tlsVHosts(ip string, port int, names []string, timeout time.Duration) ([]VHost, bool) {
+	addr := net.JoinHostPort(ip, strconv.Itoa(port))
+	base, _, err := peerCert(addr, "", timeout)
+	if err != nil {
+		return nil, false
+	}
+	var found []VHost
+	for _, name := range names {
+		sum, subject, err := peerCert(addr, name, timeout)
+		if err == nil && sum != base {
+			found = append(found, VHost{Name: name, Detail: "certificate " + subject})
+		}
+	}
+	return found, true
+}
+
+// peerCert handshakes with addr using serverName as SNI, none if empty,
+// and returns the fingerprint and subject of the leaf certificate. The
+// certificate is not verified; only whether it differs matters.
+func peerCert(addr, serverName string, timeout time.Duration) ([32]byte, string, error) {
+	probeRate.wait()
+	d := &net.Dialer{Timeout: timeout}
+	conn, err := tls.DialWithDialer(d, "tcp", addr, &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		return [32]byte{}, "", err
+	}
+	defer conn.Close()
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return [32]byte{}, "", fmt.Errorf("no certificate")
+	}
+	return sha256.Sum256(certs[0].Raw), certs[0].Subject.String(), nil
+}
+
+// httpVHosts requests / from ip:port once with the address as Host header
+// and once per name, and returns the names that get a response with a
+// different status or length. It returns nil if the port does not speak
+// HTTP.
+func httpVHosts(ip string, port int, names []string, timeout time.Duration) []VHost {
+	client := &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{DisableKeepAlives: true},
+		// A redirect to the canonical name is itself a difference.
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	url := "http://" + net.JoinHostPort(ip, strconv.Itoa(port)) + "/"
+	base, err := httpFingerprint(client, url, "")
+	if err != nil {
+		return nil
+	}
+	var found []VHost
+	for _, name := range names {
+		got, err := httpFingerprint(client, url, name)
+		if err == nil && got != base {
+			found = append(found, VHost{Name: name, Detail: got})
+		}
+	}
+	return found
+}
+
+// httpFingerprint summarises the response to a GET of url with the given
+// Host header, the default derived from url if empty, as its status and
+// body length.
+func httpFingerprint(client *http.Client, url, host string) (string, error) {
+	probeRate.wait()
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Host = host
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	n, err := io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("HTTP %d, %d bytes", resp.StatusCode, n), nil
+}
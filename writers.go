@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"io"
+)
+
+// ResultWriter renders the results of a finished scan in one format.
+type ResultWriter interface {
+	Write(w io.Writer, s *Scan) error
+}
+
+// bundleFormats are the formats -oA writes, keyed by file extension.
+var bundleFormats = []struct {
+	Ext    string
+	Writer ResultWriter
+}{
+	{"json", jsonWriter{}},
+	{"xml", xmlWriter{}},
+	{"html", htmlWriter{}},
+	{"txt", textWriter{}},
+}
+
+// writeBundle writes base.json, base.xml, base.html and base.txt, each
+// with a further .zst suffix and compressed if s.Compress is set.
+func writeBundle(base string, s *Scan) error {
+	for _, f := range bundleFormats {
+		name := base + "." + f.Ext
+		if s.Compress {
+			name += ".zst"
+		}
+		file, err := createFile(name)
+		if err != nil {
+			return err
+		}
+		err = f.Writer.Write(file, s)
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("writing %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// schemaVersion is the schema_version of the JSON and XML results. It
+// changes when fields are renamed, removed or change meaning, not when
+// fields are added; readers ignore fields they do not know.
+const schemaVersion = 1
+
+// jsonLinesWriter writes a finished scan as JSON lines, see resultStream.
+type jsonLinesWriter struct{}
+
+func (jsonLinesWriter) Write(w io.Writer, s *Scan) error {
+	return newResultStream(w).finish(s)
+}
+
+// resultStream writes results as JSON lines while the scan runs, so an
+// interrupted scan still leaves the hosts finished so far: the schema
+// version first, then each host once its port scan is done, and when
+// the scan ends the hosts the later phases changed, the cleartext
+// findings, AD domains and address family differences, if any, and the
+// scan statistics. A host record replaces any earlier one for the same
+// address. Only one goroutine writes at a time: the aggregator, then the
+// report phase.
+type resultStream struct {
+	w    io.Writer
+	sent map[string][]byte // the record last written for each address
+	err  error             // first write error; later writes are skipped
+}
+
+func newResultStream(w io.Writer) *resultStream {
+	r := &resultStream{w: w, sent: make(map[string][]byte)}
+	r.write(struct {
+		Version int `json:"schema_version"`
+	}{schemaVersion})
+	return r
+}
+
+// host writes h unless the record last written for it is the same.
+func (r *resultStream) host(h *HostResult) {
+	b, err := json.Marshal(h)
+	if err != nil {
+		r.err = cmp.Or(r.err, err)
+		return
+	}
+	if bytes.Equal(r.sent[h.IP], b) {
+		return
+	}
+	r.sent[h.IP] = b
+	r.line(b)
+}
+
+// finish writes what the scan learned after the port scan and the
+// statistics that close the stream. It returns the first error writing
+// hit.
+func (r *resultStream) finish(s *Scan) error {
+	for _, h := range s.Hosts {
+		r.host(h)
+	}
+	if len(s.Findings) > 0 {
+		r.write(struct {
+			Findings []Finding `json:"findings"`
+		}{s.Findings})
+	}
+	if len(s.Domains) > 0 {
+		r.write(struct {
+			Domains []ADDomain `json:"ad_domains"`
+		}{s.Domains})
+	}
+	if len(s.Families) > 0 {
+		r.write(struct {
+			Families []FamilyDiff `json:"family_diffs"`
+		}{s.Families})
+	}
+	// The statistics are wrapped so they cannot be mistaken for a host.
+	r.write(struct {
+		Stats *ScanStats `json:"stats"`
+	}{&s.Stats})
+	return r.err
+}
+
+func (r *resultStream) write(v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		r.err = cmp.Or(r.err, err)
+		return
+	}
+	r.line(b)
+}
+
+// line writes b as one line and pushes it through a compressor, so that
+// a reader following the file sees each record as it is written.
+func (r *resultStream) line(b []byte) {
+	if r.err != nil {
+		return
+	}
+	if _, r.err = r.w.Write(append(b, '\n')); r.err != nil {
+		return
+	}
+	if f, ok := r.w.(interface{ Flush() error }); ok {
+		r.err = f.Flush()
+	}
+}
+
+// scanReport is the document written by the JSON and XML writers.
+type scanReport struct {
+	XMLName  xml.Name      `json:"-" xml:"scan"`
+	Version  int           `json:"schema_version" xml:"schema-version,attr"`
+	Hosts    []*HostResult `json:"hosts" xml:"host"`
+	Findings []Finding     `json:"findings,omitempty" xml:"finding,omitempty"`
+	Domains  []ADDomain    `json:"ad_domains,omitempty" xml:"ad-domain,omitempty"`
+	Families []FamilyDiff  `json:"family_diffs,omitempty" xml:"family-diff,omitempty"`
+	Stats    *ScanStats    `json:"stats" xml:"stats"`
+}
+
+func newScanReport(s *Scan) scanReport {
+	return scanReport{Version: schemaVersion, Hosts: s.Hosts, Findings: s.Findings, Domains: s.Domains, Families: s.Families, Stats: &s.Stats}
+}
+
+// Groups returns the hosts grouped by DNS domain, for the HTML report.
+func (r scanReport) Groups() []hostGroup { return groupByDomain(r.Hosts) }
+
+// jsonWriter writes the whole scan as a single indented JSON document.
+type jsonWriter struct{}
+
+func (jsonWriter) Write(w io.Writer, s *Scan) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(newScanReport(s))
+}
+
+// xmlWriter writes the whole scan as an XML document.
+type xmlWriter struct{}
+
+func (xmlWriter) Write(w io.Writer, s *Scan) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(newScanReport(s)); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// textWriter writes the same summary that is printed to the console.
+type textWriter struct{}
+
+func (textWriter) Write(w io.Writer, s *Scan) error {
+	printSummary(w, s.Hosts, s.Errors)
+	printFindings(w, s.Findings)
+	printDomains(w, s.Domains)
+	printFamilyDiffs(w, s.Families)
+	printStats(w, &s.Stats)
+	return nil
+}
+
+var htmlReport = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Scan report</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+</style>
+</head>
+<body>
+<h1>Scan report</h1>
+<p>{{len .Hosts}} active hosts</p>
+<table>
+<tr><th>Host</th><th>RTT</th><th>Ports</th></tr>
+{{- $groups := .Groups}}
+{{- range $groups}}
+{{- if gt (len $groups) 1}}
+<tr><th colspan="3">{{.Title}} ({{len .Hosts}} hosts)</th></tr>
+{{- end}}
+{{- range .Hosts}}
+<tr><td>{{.IP}}{{with .Hostname}}<br>{{.}}{{end}}{{with .MAC}}<br>{{.}}{{end}}</td><td>{{.RTT}}</td><td>{{if .Honeypot}}quarantined, looks like a honeypot: {{range $i, $s := .Honeypot}}{{if $i}}; {{end}}{{$s}}{{end}}{{else}}{{range $i, $p := .Ports}}{{if $i}}, {{end}}{{$p.Port}}{{with $p.Service}} ({{.}}){{end}}{{if ne $p.State "open"}} {{$p.State}}{{end}}{{else}}none{{end}}{{end}}</td></tr>
+{{- end}}
+{{- end}}
+</table>
+{{- with .Findings}}
+<h2>Cleartext credentials risk</h2>
+<table>
+<tr><th>Severity</th><th>Host</th><th>Port</th><th>Finding</th></tr>
+{{- range .}}
+<tr><td>{{.Severity}}</td><td>{{.IP}}</td><td>{{.Port}} ({{.Service}})</td><td>{{.Detail}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- with .Families}}
+<h2>Ports open in one address family only</h2>
+<table>
+<tr><th>Name</th><th>Port</th><th>Open over</th><th>Other family</th></tr>
+{{- range .}}
+<tr><td>{{.Name}}</td><td>{{.Port}}</td><td>{{.OpenOn}}: {{range $i, $a := .Open}}{{if $i}}, {{end}}{{$a}}{{end}}</td><td>{{range $i, $a := .Other}}{{if $i}}, {{end}}{{$a}}{{end}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- range .Domains}}
+<h2>Active Directory domain {{.Name}}</h2>
+<p>Forest {{.Forest}}{{with .Level}}, domain level {{.}}{{end}}{{with .ForestLevel}}, forest level {{.}}{{end}}</p>
+<ul>
+{{- range .Found}}
+<li>found {{.}}</li>
+{{- end}}
+{{- with .DCs}}
+<li>domain controllers in DNS: {{range $i, $n := .}}{{if $i}}, {{end}}{{$n}}{{end}}</li>
+{{- end}}
+{{- with .GCs}}
+<li>global catalogs in DNS: {{range $i, $n := .}}{{if $i}}, {{end}}{{$n}}{{end}}</li>
+{{- end}}
+</ul>
+{{- end}}
+</body>
+</html>
+`))
+
+// htmlWriter writes a standalone HTML page with one table row per host.
+type htmlWriter struct{}
+
+func (htmlWriter) Write(w io.Writer, s *Scan) error {
+	return htmlReport.Execute(w, newScanReport(s))
+}